@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
@@ -9,7 +10,13 @@ import (
 
 // InitDB initializes the SQLite database connection
 func InitDB() *sql.DB {
-	db, err := sql.Open("sqlite3", "./test.db")
+	// busy_timeout makes concurrent writers wait for the lock instead of failing with SQLITE_BUSY
+	return Open("./test.db?_foreign_keys=on&_busy_timeout=5000")
+}
+
+// Open connects to the SQLite database at dsn and creates or migrates the schema
+func Open(dsn string) *sql.DB {
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -29,5 +36,335 @@ func InitDB() *sql.DB {
 		log.Fatalf("Failed to create table: %v", err)
 	}
 
+	// Region is an attribute used by authorization policies; it is managed by administrators
+	addColumnIfMissing(db, "users", "region", "TEXT")
+
+	// Failed password attempts lock the account for a while
+	addColumnIfMissing(db, "users", "failed_login_attempts", "INTEGER NOT NULL DEFAULT 0")
+	addColumnIfMissing(db, "users", "locked_until", "TIMESTAMP")
+
+	// Wrong second-factor codes are counted separately, since a correct password clears the count above
+	addColumnIfMissing(db, "users", "failed_mfa_attempts", "INTEGER NOT NULL DEFAULT 0")
+
+	// Access tokens issued before this time are rejected, e.g. after a password reset
+	addColumnIfMissing(db, "users", "sessions_revoked_at", "TIMESTAMP")
+
+	// Accounts created before email verification existed are treated as verified
+	if addColumnIfMissing(db, "users", "email_verified_at", "TIMESTAMP") {
+		if _, err := db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP"); err != nil {
+			log.Fatalf("Failed to mark existing users verified: %v", err)
+		}
+	}
+
+	// A changed address is kept aside until it is verified, so sign-in keeps using the old one
+	addColumnIfMissing(db, "users", "pending_email", "TEXT")
+
+	// A verified phone number can sign in, so it must identify a single account
+	addColumnIfMissing(db, "users", "phone_verified_at", "TIMESTAMP")
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_verified_phone ON users(phone) WHERE phone_verified_at IS NOT NULL`)
+	if err != nil {
+		log.Fatalf("Failed to create users phone index: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash TEXT NOT NULL UNIQUE,
+		family_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP,
+		replaced_by INTEGER,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create refresh_tokens table: %v", err)
+	}
+
+	// Refresh tokens issued through the OAuth token endpoint are bound to a client
+	addColumnIfMissing(db, "refresh_tokens", "client_id", "TEXT")
+	addColumnIfMissing(db, "refresh_tokens", "scope", "TEXT")
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id)`)
+	if err != nil {
+		log.Fatalf("Failed to create refresh_tokens index: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create revoked_tokens table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+		kid TEXT PRIMARY KEY,
+		alg TEXT NOT NULL,
+		secret BLOB NOT NULL,
+		retire_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create jwt_signing_keys table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS oauth_clients (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		client_id TEXT NOT NULL UNIQUE,
+		client_secret_hash TEXT,
+		name TEXT NOT NULL,
+		redirect_uris TEXT NOT NULL DEFAULT '',
+		grant_types TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create oauth_clients table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS authorization_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code_hash TEXT NOT NULL UNIQUE,
+		client_id TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		redirect_uri TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		nonce TEXT,
+		code_challenge TEXT NOT NULL,
+		code_challenge_method TEXT NOT NULL,
+		auth_time TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create authorization_codes table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL UNIQUE,
+		key_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create api_keys table: %v", err)
+	}
+
+	// Scopes restrict a key to a subset of routes (personal access tokens); empty means unrestricted
+	addColumnIfMissing(db, "api_keys", "scopes", "TEXT")
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS user_mfa (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		totp_secret TEXT NOT NULL,
+		totp_enabled_at TIMESTAMP,
+		totp_last_step INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create user_mfa table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS mfa_challenges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token_hash TEXT NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at TIMESTAMP NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create mfa_challenges table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create mfa_recovery_codes table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user ON mfa_recovery_codes(user_id)`)
+	if err != nil {
+		log.Fatalf("Failed to create mfa_recovery_codes index: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		credential_id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		credential TEXT NOT NULL,
+		last_used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create webauthn_credentials table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS webauthn_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token_hash TEXT NOT NULL UNIQUE,
+		user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+		purpose TEXT NOT NULL,
+		data TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create webauthn_sessions table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create password_reset_tokens table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS password_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		password_hash TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create password_history table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history(user_id)`)
+	if err != nil {
+		log.Fatalf("Failed to create password_history index: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS phone_otps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		phone TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Fatalf("Failed to create phone_otps table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_phone_otps_user ON phone_otps(user_id, purpose)`)
+	if err != nil {
+		log.Fatalf("Failed to create phone_otps index: %v", err)
+	}
+
+	createRBACTables(db)
+
 	return db
 }
+
+// defaultPermissions are the permissions checked by the routes package
+var defaultPermissions = map[string]string{
+	"users:read":     "Read any user's details",
+	"users:write":    "Update any user's details",
+	"users:delete":   "Delete any user",
+	"roles:manage":   "Manage roles, permissions and assignments",
+	"clients:manage": "Register and list OAuth clients",
+	"keys:manage":    "Rotate token signing keys",
+}
+
+// createRBACTables creates the role-based access control tables and seeds the
+// default permissions and an admin role holding all of them
+func createRBACTables(db *sql.DB) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS roles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS permissions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE IF NOT EXISTS role_permissions (
+			role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+			permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+			PRIMARY KEY (role_id, permission_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_roles (
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, role_id)
+		)`,
+		`INSERT OR IGNORE INTO roles (name, description) VALUES ('admin', 'Full administrative access')`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			log.Fatalf("Failed to create RBAC tables: %v", err)
+		}
+	}
+
+	for name, description := range defaultPermissions {
+		_, err := db.Exec("INSERT OR IGNORE INTO permissions (name, description) VALUES (?, ?)", name, description)
+		if err != nil {
+			log.Fatalf("Failed to seed permission %s: %v", name, err)
+		}
+		_, err = db.Exec(`INSERT OR IGNORE INTO role_permissions (role_id, permission_id)
+			SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = ?`, name)
+		if err != nil {
+			log.Fatalf("Failed to grant permission %s to admin: %v", name, err)
+		}
+	}
+}
+
+// addColumnIfMissing adds a column to an existing table created by an older
+// version. It reports whether the column was added.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) bool {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		log.Fatalf("Failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			log.Fatalf("Failed to inspect table %s: %v", table, err)
+		}
+		if name == column {
+			return false
+		}
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		log.Fatalf("Failed to add column %s.%s: %v", table, column, err)
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// OutboxMailer writes each message to a .eml file in Dir instead of sending
+// it, for local development and tests
+type OutboxMailer struct {
+	Dir  string
+	From string
+}
+
+var outboxSeq atomic.Int64
+
+// Send implements Mailer
+func (m OutboxMailer) Send(msg Message) error {
+	for _, value := range []string{msg.To, msg.Subject} {
+		if err := validHeader(value); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), outboxSeq.Add(1))
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o600)
+}
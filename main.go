@@ -1,21 +1,262 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"golang_projects/database"
+	"golang_projects/mailer"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/password"
+	"golang_projects/policy"
+	"golang_projects/ratelimit"
+	"golang_projects/repository"
 	"golang_projects/routes"
+	utils "golang_projects/utility"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 func main() {
+	// Load the JWT signing keys
+	if err := utils.InitJWTKeys(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	// Load the key that encrypts secrets stored in the database
+	if err := utils.InitEncryptionKey(); err != nil {
+		log.Fatalf("Failed to load encryption key: %v", err)
+	}
+
 	// Initialize the database connection
 	db := database.InitDB()
 	defer db.Close()
 
+	// Reject revoked tokens and keep the denylist small
+	utils.SetRevocationChecker(func(jti string) (bool, error) {
+		return repository.IsTokenRevoked(db, jti)
+	})
+	utils.SetSessionRevocationChecker(func(userID int, issuedAt time.Time) (bool, error) {
+		revokedAt, err := repository.SessionsRevokedAt(db, userID)
+		return issuedAt.Before(revokedAt), err
+	})
+	repository.StartRevokedTokenPruner(db, time.Hour)
+
+	// Accept user API keys wherever access tokens are accepted
+	middleware.SetAPIKeyAuthenticator(func(key string) (middleware.Principal, error) {
+		return middleware.AuthenticateAPIKey(db, key)
+	})
+
+	// Restore HMAC keys created by runtime rotations
+	loadRotatedSigningKeys(db)
+	encryptTOTPSecrets(db)
+
+	// Grant the admin role to the bootstrap administrator, if configured
+	if email := os.Getenv("BOOTSTRAP_ADMIN_EMAIL"); email != "" {
+		if _, err := repository.AssignRoleToUserByEmail(db, email, "admin"); err != nil {
+			log.Printf("Failed to assign admin role to %s: %v", email, err)
+		}
+	}
+
+	// Load authorization policies
+	policies := loadPolicies(utils.GetEnv("POLICY_FILE", "policies.json"))
+
+	// Load the password policy
+	passwords := newPasswordPolicy()
+
 	// Setup router
-	router := routes.SetupRoutes(db)
+	router := routes.SetupRoutes(db, routes.Config{
+		Policies:          policies,
+		WebAuthn:          newWebAuthn(),
+		Mailer:            newMailer(),
+		Passwords:         passwords,
+		ClientIP:          newIPResolver(),
+		DisableRateLimits: !utils.GetEnvBool("RATE_LIMITS_ENABLED", true),
+	})
 
 	// Start the server
 	log.Println("Server running on http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
+
+// loadRotatedSigningKeys adds persisted rotated keys to the key ring; the newest
+// key that is not scheduled for retirement becomes the signing key.
+func loadRotatedSigningKeys(db *sql.DB) {
+	if _, err := repository.DeleteRetiredSigningKeys(db); err != nil {
+		log.Printf("Delete retired signing keys error: %v", err)
+	}
+
+	keys, err := repository.GetActiveSigningKeys(db)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	for _, key := range keys {
+		secret, err := signingKeySecret(db, key)
+		if err != nil {
+			log.Printf("Skipping rotated signing key %s: %v", key.Kid, err)
+			continue
+		}
+		err = utils.RestoreHMACKey(key.Kid, key.Alg, secret, key.RetireAt.Time, !key.RetireAt.Valid)
+		if err != nil {
+			log.Printf("Skipping rotated signing key %s: %v", key.Kid, err)
+		}
+	}
+}
+
+// signingKeySecret decrypts a rotated key's secret. Secrets stored in plaintext
+// before encryption at rest was introduced are encrypted in place.
+func signingKeySecret(db *sql.DB, key model.SigningKey) ([]byte, error) {
+	purpose := utils.SigningKeyPurpose(key.Kid)
+	if utils.IsEncryptedSecret(string(key.Secret)) {
+		return utils.DecryptSecret(string(key.Secret), purpose)
+	}
+
+	sealed, err := utils.EncryptSecret(key.Secret, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.UpdateSigningKeySecret(db, key.Kid, []byte(sealed)); err != nil {
+		return nil, err
+	}
+	return key.Secret, nil
+}
+
+// encryptTOTPSecrets encrypts TOTP secrets stored in plaintext before
+// encryption at rest was introduced
+func encryptTOTPSecrets(db *sql.DB) {
+	secrets, err := repository.GetTOTPSecrets(db)
+	if err != nil {
+		log.Fatalf("Failed to load TOTP secrets: %v", err)
+	}
+	for userID, secret := range secrets {
+		if utils.IsEncryptedSecret(secret) {
+			continue
+		}
+		sealed, err := utils.EncryptSecret([]byte(secret), utils.TOTPSecretPurpose(userID))
+		if err == nil {
+			err = repository.UpdateTOTPSecret(db, userID, sealed)
+		}
+		if err != nil {
+			log.Fatalf("Failed to encrypt TOTP secret of user %d: %v", userID, err)
+		}
+	}
+}
+
+// loadPolicies loads the attribute-based authorization policies. A missing
+// file means no policies, so role permissions alone decide.
+func loadPolicies(path string) *policy.Engine {
+	engine, err := policy.LoadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Policy file %s not found, no authorization policies loaded", path)
+		engine, _ = policy.NewEngine(nil)
+		return engine
+	}
+	if err != nil {
+		log.Fatalf("Failed to load policies: %v", err)
+	}
+	return engine
+}
+
+// newWebAuthn configures the WebAuthn relying party. The RP id defaults to
+// the issuer's host and the allowed origins to the issuer itself.
+func newWebAuthn() *webauthn.WebAuthn {
+	issuer, err := url.Parse(utils.Issuer)
+	if err != nil {
+		log.Fatalf("Invalid ISSUER_URL: %v", err)
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(utils.GetEnv("WEBAUTHN_RP_ORIGINS", utils.Issuer), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:                  utils.GetEnv("WEBAUTHN_RP_ID", issuer.Hostname()),
+		RPDisplayName:         utils.GetEnv("WEBAUTHN_RP_NAME", "Go-Authentication"),
+		RPOrigins:             origins,
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure WebAuthn: %v", err)
+	}
+	return wa
+}
+
+// newMailer selects how account email is delivered: MAILER=smtp sends through
+// SMTP_ADDR, anything else writes messages to OUTBOX_DIR for local use
+func newMailer() mailer.Mailer {
+	from := utils.GetEnv("MAIL_FROM", "no-reply@localhost")
+	if utils.GetEnv("MAILER", "outbox") == "smtp" {
+		addr := utils.GetEnv("SMTP_ADDR", "")
+		if addr == "" {
+			log.Fatalf("SMTP_ADDR is required when MAILER=smtp")
+		}
+		return mailer.SMTPMailer{
+			Addr:     addr,
+			From:     from,
+			Username: utils.GetEnv("SMTP_USERNAME", ""),
+			Password: utils.GetEnv("SMTP_PASSWORD", ""),
+		}
+	}
+	return mailer.OutboxMailer{Dir: utils.GetEnv("OUTBOX_DIR", "outbox"), From: from}
+}
+
+// newIPResolver trusts X-Forwarded-For from the proxies listed in
+// TRUSTED_PROXIES, a comma-separated list of addresses or CIDR ranges
+func newIPResolver() *ratelimit.IPResolver {
+	resolver, err := ratelimit.NewIPResolver(strings.Split(utils.GetEnv("TRUSTED_PROXIES", ""), ","))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	return resolver
+}
+
+// newPasswordPolicy builds the password policy from PASSWORD_* settings, on top
+// of password.DefaultPolicy
+func newPasswordPolicy() *password.Policy {
+	p := password.DefaultPolicy()
+	p.MinLength = utils.GetEnvInt("PASSWORD_MIN_LENGTH", p.MinLength)
+	p.MaxLength = utils.GetEnvInt("PASSWORD_MAX_LENGTH", p.MaxLength)
+	p.RequireUpper = utils.GetEnvBool("PASSWORD_REQUIRE_UPPER", p.RequireUpper)
+	p.RequireLower = utils.GetEnvBool("PASSWORD_REQUIRE_LOWER", p.RequireLower)
+	p.RequireDigit = utils.GetEnvBool("PASSWORD_REQUIRE_DIGIT", p.RequireDigit)
+	p.RequireSymbol = utils.GetEnvBool("PASSWORD_REQUIRE_SYMBOL", p.RequireSymbol)
+	p.History = utils.GetEnvInt("PASSWORD_HISTORY", p.History)
+
+	if words := utils.GetEnv("PASSWORD_BANNED_WORDS", ""); words != "" {
+		for _, word := range strings.Split(words, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				p.BannedWords = append(p.BannedWords, word)
+			}
+		}
+	}
+
+	if value := utils.GetEnv("PASSWORD_MAX_SIMILARITY", ""); value != "" {
+		similarity, err := strconv.ParseFloat(value, 64)
+		if err != nil || similarity < 0 || similarity > 1 {
+			log.Fatalf("Invalid PASSWORD_MAX_SIMILARITY %q: must be between 0 and 1", value)
+		}
+		p.MaxSimilarity = similarity
+	}
+
+	// The breached password list is optional, but a configured list must load
+	if path := utils.GetEnv("PASSWORD_BREACHED_FILE", ""); path != "" {
+		list, err := password.LoadBreachedList(path)
+		if err != nil {
+			log.Fatalf("Failed to load breached password list: %v", err)
+		}
+		p.Breached = list
+		log.Printf("Loaded %d breached password hashes from %s", list.Len(), path)
+	}
+	return p
+}
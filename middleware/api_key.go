@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"time"
+)
+
+// Errors returned when an API key is rejected
+var (
+	ErrAPIKeyInvalid = errors.New("api key is invalid")
+	ErrAPIKeyExpired = errors.New("api key has expired")
+	ErrAPIKeyRevoked = errors.New("api key has been revoked")
+)
+
+// apiKeyAuthenticator resolves an API key to its principal; nil disables API keys
+var apiKeyAuthenticator func(key string) (Principal, error)
+
+// SetAPIKeyAuthenticator registers the lookup used by JWTAuthMiddleware to accept API keys
+func SetAPIKeyAuthenticator(authenticator func(key string) (Principal, error)) {
+	apiKeyAuthenticator = authenticator
+}
+
+// AuthenticateAPIKey verifies an API key against its stored hash and returns
+// the owning user's principal. Roles are loaded from the database so that
+// role changes apply to existing keys immediately.
+func AuthenticateAPIKey(db *sql.DB, key string) (Principal, error) {
+	prefix, ok := utils.APIKeyPrefixOf(key)
+	if !ok {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+
+	stored, err := repository.GetAPIKeyByPrefix(db, prefix)
+	if err == sql.ErrNoRows {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(key)), []byte(stored.KeyHash)) != 1 {
+		return Principal{}, ErrAPIKeyInvalid
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil {
+		return Principal{}, ErrAPIKeyRevoked
+	}
+	if !stored.Active(now) {
+		return Principal{}, ErrAPIKeyExpired
+	}
+
+	roles, err := repository.GetUserRoles(db, stored.UserID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if err := repository.TouchAPIKey(db, stored.ID); err != nil {
+		log.Printf("Touch API key error: %v", err)
+	}
+
+	p := Principal{
+		SubjectType: utils.SubjectTypeUser,
+		UserID:      stored.UserID,
+		Roles:       roles,
+		Scopes:      stored.Scopes,
+		APIKeyID:    stored.ID,
+	}
+	if stored.ExpiresAt != nil {
+		p.ExpiresAt = *stored.ExpiresAt
+	}
+	return p, nil
+}
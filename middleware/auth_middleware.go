@@ -1,30 +1,126 @@
 package middleware
 
 import (
+	"errors"
 	utils "golang_projects/utility"
+	"log"
 	"net/http"
 	"strings"
 )
 
+// Errors returned by Authenticate besides token and API key errors
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	errAPIKeysDisabled    = errors.New("api keys are not accepted")
+)
+
+// apiKeyError marks an error returned while authenticating an API key
+type apiKeyError struct {
+	err error
+}
+
+func (e apiKeyError) Error() string { return e.err.Error() }
+func (e apiKeyError) Unwrap() error { return e.err }
+
+// JWTAuthMiddleware validates the bearer token and stores the caller's Principal in the request context.
+// API keys are accepted as an alternative credential, either as the bearer
+// token or in the X-API-Key header.
 func JWTAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-
-		if authHeader == "" {
-			//mak json response if
-			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Missing Authorization header", nil)
+		principal, err := Authenticate(r)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, authErrorMessage(err), nil)
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		_, err := utils.ValidateJWT(token)
+		// Make the caller's identity available to handlers
+		ctx := WithPrincipal(r.Context(), principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+}
+
+// Authenticate resolves the caller's Principal from the request's bearer token
+// or API key without storing it in the request context
+func Authenticate(r *http.Request) (Principal, error) {
+	authHeader := r.Header.Get("Authorization")
+	apiKey := r.Header.Get("X-API-Key")
+
+	if authHeader == "" && apiKey == "" {
+		return Principal{}, ErrMissingCredentials
+	}
+
+	// Extract token from "Bearer <token>"
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if apiKey == "" && strings.HasPrefix(token, utils.APIKeyPrefix) {
+		apiKey = token
+	}
+	if apiKey != "" {
+		if apiKeyAuthenticator == nil {
+			return Principal{}, errAPIKeysDisabled
+		}
+		principal, err := apiKeyAuthenticator(apiKey)
 		if err != nil {
-			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired token", nil)
-			return
+			return Principal{}, apiKeyError{err}
 		}
+		return principal, nil
+	}
 
-		next.ServeHTTP(w, r)
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		return Principal{}, err
 	}
+	return principalFromClaims(claims), nil
+}
 
+// authErrorMessage returns the client-facing reason a request could not be authenticated
+func authErrorMessage(err error) string {
+	var keyErr apiKeyError
+	switch {
+	case errors.Is(err, ErrMissingCredentials):
+		return "Missing Authorization header"
+	case errors.Is(err, errAPIKeysDisabled):
+		return "API keys are not accepted"
+	case errors.As(err, &keyErr):
+		return apiKeyErrorMessage(keyErr.err)
+	default:
+		return tokenErrorMessage(err)
+	}
+}
+
+// apiKeyErrorMessage returns the client-facing reason an API key was rejected
+func apiKeyErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrAPIKeyExpired):
+		return "API key has expired"
+	case errors.Is(err, ErrAPIKeyRevoked):
+		return "API key has been revoked"
+	case errors.Is(err, ErrAPIKeyInvalid):
+		return "Invalid API key"
+	default:
+		log.Printf("API key authentication error: %v", err)
+		return "Failed to authenticate API key"
+	}
+}
+
+// tokenErrorMessage returns the client-facing reason a token was rejected
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, utils.ErrTokenExpired):
+		return "Token has expired"
+	case errors.Is(err, utils.ErrTokenNotYetValid), errors.Is(err, utils.ErrTokenUsedBeforeIssued):
+		return "Token is not valid yet"
+	case errors.Is(err, utils.ErrTokenInvalidIssuer):
+		return "Token was issued by an untrusted issuer"
+	case errors.Is(err, utils.ErrTokenInvalidAudience):
+		return "Token is not intended for this service"
+	case errors.Is(err, utils.ErrTokenMissingClaim):
+		return "Token is missing required claims"
+	case errors.Is(err, utils.ErrTokenRevoked):
+		return "Token has been revoked"
+	case errors.Is(err, utils.ErrTokenInvalidSignature):
+		return "Token signature is invalid"
+	default:
+		return "Invalid or expired token"
+	}
 }
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	utils "golang_projects/utility"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Principal is the authenticated identity behind a request
+type Principal struct {
+	// SubjectType is utils.SubjectTypeUser or utils.SubjectTypeClient
+	SubjectType string
+	// UserID is set for users; ClientID for service clients and OAuth-issued tokens
+	UserID    int
+	ClientID  string
+	Roles     []string
+	Scopes    []string
+	TokenID   string
+	ExpiresAt time.Time
+	// APIKeyID is set when the request authenticated with an API key instead of a JWT
+	APIKeyID int
+}
+
+// IsUser reports whether the principal is a human user
+func (p Principal) IsUser() bool {
+	return p.SubjectType == utils.SubjectTypeUser
+}
+
+// IsDelegated reports whether the principal is a user acting through an OAuth
+// client, limited to the scopes the user granted that client
+func (p Principal) IsDelegated() bool {
+	return p.IsUser() && p.ClientID != ""
+}
+
+// HasRole reports whether the principal has the given role
+func (p Principal) HasRole(role string) bool {
+	return containsString(p.Roles, role)
+}
+
+// HasScope reports whether the principal's token carries the given scope
+func (p Principal) HasScope(scope string) bool {
+	return containsString(p.Scopes, scope)
+}
+
+// hasAnyScope reports whether the principal's token carries one of the scopes
+func (p Principal) hasAnyScope(scopes []string) bool {
+	for _, scope := range scopes {
+		if p.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// PrincipalFromRequest returns the principal of an authenticated request
+func PrincipalFromRequest(r *http.Request) (Principal, bool) {
+	return PrincipalFromContext(r.Context())
+}
+
+// principalFromClaims builds a principal from validated access token claims
+func principalFromClaims(claims jwt.MapClaims) Principal {
+	p := Principal{SubjectType: utils.SubjectTypeUser}
+	if subType, ok := claims["sub_type"].(string); ok && subType != "" {
+		p.SubjectType = subType
+	}
+
+	sub, _ := claims["sub"].(string)
+	if p.IsUser() {
+		p.UserID, _ = strconv.Atoi(sub)
+	}
+	p.ClientID, _ = claims["client_id"].(string)
+	p.TokenID, _ = claims["jti"].(string)
+
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	}
+	if roles, ok := claims["roles"].([]interface{}); ok {
+		for _, role := range roles {
+			if name, ok := role.(string); ok {
+				p.Roles = append(p.Roles, name)
+			}
+		}
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		p.ExpiresAt = exp.Time
+	}
+	return p
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIKey reports whether the principal authenticated with an API key
+func (p Principal) IsAPIKey() bool {
+	return p.APIKeyID != 0
+}
+
+// IsScopeRestricted reports whether the principal may only reach the routes
+// its scopes allow: personal access tokens (API keys with scopes) and every
+// token issued to an OAuth client, whether for a user or the client itself.
+// Only first-party logins and unscoped API keys are unrestricted.
+func (p Principal) IsScopeRestricted() bool {
+	if p.IsAPIKey() {
+		return len(p.Scopes) > 0
+	}
+	return p.ClientID != "" || !p.IsUser()
+}
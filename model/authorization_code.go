@@ -0,0 +1,22 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AuthorizationCode represents a hashed, single-use OAuth authorization code
+type AuthorizationCode struct {
+	ID                  int          `db:"id"`
+	CodeHash            string       `db:"code_hash"`
+	ClientID            string       `db:"client_id"`
+	UserID              int          `db:"user_id"`
+	RedirectURI         string       `db:"redirect_uri"`
+	Scope               string       `db:"scope"`
+	Nonce               string       `db:"nonce"`
+	CodeChallenge       string       `db:"code_challenge"`
+	CodeChallengeMethod string       `db:"code_challenge_method"`
+	AuthTime            time.Time    `db:"auth_time"`
+	ExpiresAt           time.Time    `db:"expires_at"`
+	UsedAt              sql.NullTime `db:"used_at"`
+}
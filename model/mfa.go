@@ -0,0 +1,35 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UserMFA holds a user's TOTP enrollment. The secret is stored as soon as
+// enrollment starts; TOTP is only enforced once TOTPEnabledAt is set by a
+// confirmed code.
+type UserMFA struct {
+	UserID        int          `db:"user_id"`
+	TOTPSecret    string       `db:"totp_secret"` // encrypted with utils.EncryptSecret
+	TOTPEnabledAt sql.NullTime `db:"totp_enabled_at"`
+	// TOTPLastStep is the time step of the last accepted code, used to reject replays
+	TOTPLastStep int64     `db:"totp_last_step"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// TOTPEnabled reports whether the user must present a TOTP code to sign in
+func (m UserMFA) TOTPEnabled() bool {
+	return m.TOTPEnabledAt.Valid
+}
+
+// MFAChallenge is the pending second step of a login whose password was
+// accepted. Only the hash of the challenge token is stored.
+type MFAChallenge struct {
+	ID        int          `db:"id"`
+	TokenHash string       `db:"token_hash"`
+	UserID    int          `db:"user_id"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	Attempts  int          `db:"attempts"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// OAuthClient represents an application registered to use the OAuth2 / OpenID Connect endpoints
+type OAuthClient struct {
+	ID               int       `json:"id" db:"id"`
+	ClientID         string    `json:"client_id" db:"client_id"`
+	ClientSecretHash string    `json:"-" db:"client_secret_hash"`
+	Name             string    `json:"name" db:"name"`
+	RedirectURIs     []string  `json:"redirect_uris" db:"redirect_uris"`
+	GrantTypes       []string  `json:"grant_types" db:"grant_types"`
+	Scopes           []string  `json:"scopes" db:"scopes"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+// IsConfidential reports whether the client authenticates with a secret
+func (c OAuthClient) IsConfidential() bool {
+	return c.ClientSecretHash != ""
+}
+
+// HasRedirectURI reports whether the redirect URI exactly matches a registered one
+func (c OAuthClient) HasRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrant reports whether the client may use the grant type
+func (c OAuthClient) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsScopes reports whether every requested scope is registered for the client
+func (c OAuthClient) AllowsScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !contains(c.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PasswordResetToken is an emailed, single-use token allowing a user to set a
+// new password without signing in. Only the hash of the token is stored.
+type PasswordResetToken struct {
+	ID        int          `db:"id"`
+	UserID    int          `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Phone OTP purposes; a code sent for one purpose cannot be used for another
+const (
+	PhoneOTPPurposeVerify = "verify_phone"
+	PhoneOTPPurposeLogin  = "login"
+)
+
+// PhoneOTP is a one-time code sent by SMS to Phone. Only the hash of the code
+// is stored.
+type PhoneOTP struct {
+	ID        int          `db:"id"`
+	UserID    int          `db:"user_id"`
+	Phone     string       `db:"phone"`
+	Purpose   string       `db:"purpose"`
+	CodeHash  string       `db:"code_hash"`
+	Attempts  int          `db:"attempts"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RefreshToken represents a stored (hashed) refresh token. Tokens rotated from
+// the same login share a FamilyID so that reuse can revoke the whole chain.
+type RefreshToken struct {
+	ID         int           `db:"id"`
+	UserID     int           `db:"user_id"`
+	TokenHash  string        `db:"token_hash"`
+	FamilyID   string        `db:"family_id"`
+	ExpiresAt  time.Time     `db:"expires_at"`
+	RevokedAt  sql.NullTime  `db:"revoked_at"`
+	ReplacedBy sql.NullInt64 `db:"replaced_by"`
+	// ClientID and Scope are set for tokens issued to OAuth clients
+	ClientID  sql.NullString `db:"client_id"`
+	Scope     sql.NullString `db:"scope"`
+	CreatedAt time.Time      `db:"created_at"`
+}
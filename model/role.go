@@ -0,0 +1,16 @@
+package model
+
+// Role represents a named set of permissions that can be assigned to users
+type Role struct {
+	ID          int      `json:"id" db:"id"`
+	Name        string   `json:"name" db:"name"`
+	Description string   `json:"description" db:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// Permission represents an action that routes can require
+type Permission struct {
+	ID          int    `json:"id" db:"id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SigningKey represents an HMAC signing key created by a runtime rotation
+type SigningKey struct {
+	Kid       string       `db:"kid"`
+	Alg       string       `db:"alg"`
+	Secret    []byte       `db:"secret"` // encrypted with utils.EncryptSecret
+	RetireAt  sql.NullTime `db:"retire_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
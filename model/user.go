@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // User represents a user in the database
 type User struct {
 	ID       int    `json:"id" db:"id"`
@@ -8,4 +10,13 @@ type User struct {
 	Password string `json:"password,omitempty" db:"password" validate:"required,min=6"`
 	Phone    string `json:"phone" db:"phone" validate:"min=10"`
 	Address  string `json:"address" db:"address" validate:"min=5"`
+	Region   string `json:"region,omitempty" db:"region"`
+	// EmailVerified is set once the user follows the link sent to Email
+	EmailVerified bool `json:"email_verified" db:"email_verified"`
+	// PendingEmail is a new address that replaces Email once it is verified
+	PendingEmail string `json:"pending_email,omitempty" db:"pending_email"`
+	// PhoneVerified is set once the user enters a code sent to Phone by SMS
+	PhoneVerified bool `json:"phone_verified" db:"phone_verified"`
+	// LockedUntil is set while the account is locked after repeated failed logins
+	LockedUntil *time.Time `json:"locked_until,omitempty" db:"locked_until"`
 }
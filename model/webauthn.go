@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthnCredential is a passkey or security key registered to a user.
+// CredentialID is the base64url encoded WebAuthn credential id; Credential
+// holds the public key, sign counter and flags needed to verify assertions.
+type WebAuthnCredential struct {
+	ID           int                 `json:"id" db:"id"`
+	UserID       int                 `json:"user_id" db:"user_id"`
+	CredentialID string              `json:"credential_id" db:"credential_id"`
+	Name         string              `json:"name" db:"name"`
+	Credential   webauthn.Credential `json:"-" db:"credential"`
+	LastUsedAt   *time.Time          `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt    time.Time           `json:"created_at" db:"created_at"`
+}
+
+// WebAuthn ceremony purposes
+const (
+	WebAuthnPurposeRegistration = "registration"
+	WebAuthnPurposeLogin        = "login"
+	WebAuthnPurposeMFA          = "mfa"
+)
+
+// WebAuthnSession is the server side state of a registration or assertion
+// ceremony: the challenge sent to the browser, bound to an opaque session
+// token whose hash is stored.
+type WebAuthnSession struct {
+	ID        int                  `db:"id"`
+	TokenHash string               `db:"token_hash"`
+	UserID    int                  `db:"user_id"`
+	Purpose   string               `db:"purpose"`
+	Data      webauthn.SessionData `db:"data"`
+	ExpiresAt time.Time            `db:"expires_at"`
+	CreatedAt time.Time            `db:"created_at"`
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BreachedList is an offline list of SHA-1 hashes, or hash prefixes, of
+// passwords known from data breaches. A prefix entry rejects every password
+// whose hash starts with it, which lets a large list be trimmed to a
+// manageable size at the cost of some false positives.
+type BreachedList struct {
+	// prefixes holds the entries grouped by length, longest first
+	prefixes map[int]map[string]struct{}
+	lengths  []int
+	size     int
+}
+
+// minBreachedPrefix is the shortest prefix accepted; shorter ones would
+// reject a large share of all passwords
+const minBreachedPrefix = 5
+
+// LoadBreachedList reads a list with one uppercase or lowercase hex SHA-1 hash
+// or prefix per line. Anything after a colon is ignored, so files in the Have
+// I Been Pwned "HASH:COUNT" format can be used directly. Blank lines and lines
+// starting with # are skipped.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &BreachedList{prefixes: make(map[int]map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if colon := strings.IndexByte(entry, ':'); colon >= 0 {
+			entry = entry[:colon]
+		}
+		entry = strings.ToUpper(entry)
+		if len(entry) < minBreachedPrefix || len(entry) > sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: entry must be %d to %d hex characters", path, line, minBreachedPrefix, sha1.Size*2)
+		}
+		if _, err := hex.DecodeString(entry + strings.Repeat("0", len(entry)%2)); err != nil {
+			return nil, fmt.Errorf("%s:%d: entry is not hex", path, line)
+		}
+		list.add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *BreachedList) add(prefix string) {
+	set, ok := l.prefixes[len(prefix)]
+	if !ok {
+		set = make(map[string]struct{})
+		l.prefixes[len(prefix)] = set
+		l.lengths = append(l.lengths, len(prefix))
+		sort.Sort(sort.Reverse(sort.IntSlice(l.lengths)))
+	}
+	if _, ok := set[prefix]; !ok {
+		set[prefix] = struct{}{}
+		l.size++
+	}
+}
+
+// Len returns the number of entries in the list
+func (l *BreachedList) Len() int {
+	return l.size
+}
+
+// Contains reports whether the password's SHA-1 hash matches an entry
+func (l *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	for _, length := range l.lengths {
+		if _, ok := l.prefixes[length][hash[:length]]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+// Package password decides whether a new password is acceptable.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBcryptBytes is the longest password bcrypt can hash
+const MaxBcryptBytes = 72
+
+// Policy is the set of rules a new password must satisfy
+type Policy struct {
+	// MinLength is the minimum length in characters. MaxLength is the maximum
+	// length in bytes, since bcrypt cannot hash more than MaxBcryptBytes; 0
+	// or anything larger means MaxBcryptBytes.
+	MinLength int
+	MaxLength int
+
+	// Character classes the password must contain
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// BannedWords may not appear anywhere in the password, ignoring case and
+	// common letter substitutions such as 0 for o
+	BannedWords []string
+
+	// MaxSimilarity rejects passwords whose similarity (0 to 1) to the user's
+	// email address or name reaches it, or that contain either; 0 disables
+	// the check
+	MaxSimilarity float64
+
+	// Breached rejects passwords known from data breaches; nil disables the check
+	Breached *BreachedList
+
+	// History is how many of the user's most recent passwords, including the
+	// current one, may not be reused; 0 allows reuse
+	History int
+}
+
+// PreviousPasswords returns how many passwords before the current one must be
+// remembered to enforce History
+func (p *Policy) PreviousPasswords() int {
+	if p.History <= 1 {
+		return 0
+	}
+	return p.History - 1
+}
+
+// DefaultPolicy returns the rules used when none are configured
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:     6,
+		MaxLength:     MaxBcryptBytes,
+		RequireSymbol: true,
+		MaxSimilarity: 0.7,
+		History:       5,
+	}
+}
+
+// User is the personal information a password is compared with
+type User struct {
+	Email string
+	Name  string
+}
+
+// PolicyError lists every rule a password breaks
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks a password against the policy. It returns a *PolicyError
+// listing every rule the password breaks, or nil.
+func (p *Policy) Validate(password string, user User) error {
+	if password == "" {
+		return &PolicyError{Violations: []string{"is required"}}
+	}
+
+	var violations []string
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if maxBytes := p.maxBytes(); len(password) > maxBytes {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", maxBytes))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsSymbol(r) || unicode.IsPunct(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must include an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must include a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must include a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must include at least one special character")
+	}
+
+	normalized := normalize(password)
+	for _, word := range p.BannedWords {
+		if word = normalize(word); word != "" && strings.Contains(normalized, word) {
+			violations = append(violations, "must not contain common words or phrases")
+			break
+		}
+	}
+
+	if p.MaxSimilarity > 0 && p.tooSimilar(strings.ToLower(password), user) {
+		violations = append(violations, "must not be similar to your email address or name")
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, "has appeared in a data breach; choose a different one")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// maxBytes returns the effective maximum length in bytes
+func (p *Policy) maxBytes() int {
+	if p.MaxLength <= 0 || p.MaxLength > MaxBcryptBytes {
+		return MaxBcryptBytes
+	}
+	return p.MaxLength
+}
+
+// tooSimilar compares the password with the email address, its local part,
+// the name and each word of the name
+func (p *Policy) tooSimilar(password string, user User) bool {
+	email := strings.ToLower(strings.TrimSpace(user.Email))
+	name := strings.ToLower(strings.TrimSpace(user.Name))
+
+	candidates := []string{email, name}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		candidates = append(candidates, email[:at])
+	}
+	candidates = append(candidates, strings.Fields(name)...)
+
+	for _, candidate := range candidates {
+		// Very short names would match too many passwords
+		if utf8.RuneCountInString(candidate) < 3 {
+			continue
+		}
+		if strings.Contains(password, candidate) || similarity(password, candidate) >= p.MaxSimilarity {
+			return true
+		}
+	}
+	return false
+}
+
+// similarity is 1 minus the edit distance relative to the longer string
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// substitutions undoes common letter substitutions before banned words are matched
+var substitutions = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+// normalize lowercases s and undoes common substitutions
+func normalize(s string) string {
+	return substitutions.Replace(strings.ToLower(strings.TrimSpace(s)))
+}
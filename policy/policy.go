@@ -0,0 +1,230 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Effect is the outcome a policy produces when it matches
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+	// NotApplicable means no policy matched; callers fall back to their default rules
+	NotApplicable Effect = "not_applicable"
+)
+
+// Attributes describe a subject or resource, e.g. {"id": 4, "roles": ["support"], "region": "eu"}
+type Attributes map[string]interface{}
+
+// Request is an authorization question: may subject perform action on resource?
+type Request struct {
+	Subject  Attributes
+	Resource Attributes
+	Action   string
+}
+
+// Decision is the result of evaluating a request
+type Decision struct {
+	Effect   Effect
+	PolicyID string
+}
+
+// Evaluator decides authorization requests. Implementations must be safe for concurrent use.
+type Evaluator interface {
+	Evaluate(req Request) Decision
+}
+
+// Condition compares an attribute ("subject.region", "resource.id", "action")
+// with a literal Value or with another attribute named by ValueFrom.
+//
+// Operators: eq, neq, in (attribute is one of Value), contains (attribute
+// list contains Value), exists, not_exists. A comparison with a missing
+// attribute on either side holds in deny policies and fails in allow policies.
+type Condition struct {
+	Attribute string      `json:"attribute"`
+	Operator  string      `json:"operator"`
+	Value     interface{} `json:"value,omitempty"`
+	ValueFrom string      `json:"value_from,omitempty"`
+}
+
+// Policy applies its Effect when the action matches and every condition holds.
+// Actions may be exact ("user:read"), a prefix wildcard ("user:*") or "*".
+type Policy struct {
+	ID          string      `json:"id"`
+	Description string      `json:"description,omitempty"`
+	Effect      Effect      `json:"effect"`
+	Actions     []string    `json:"actions"`
+	Conditions  []Condition `json:"conditions"`
+}
+
+// Engine evaluates a fixed list of policies with deny-overrides combining:
+// any matching deny wins, otherwise any matching allow, otherwise NotApplicable.
+type Engine struct {
+	policies []Policy
+}
+
+// NewEngine validates the policies and returns an engine for them
+func NewEngine(policies []Policy) (*Engine, error) {
+	for i, p := range policies {
+		if p.ID == "" {
+			return nil, fmt.Errorf("policy %d: id is required", i)
+		}
+		if p.Effect != Allow && p.Effect != Deny {
+			return nil, fmt.Errorf("policy %s: effect must be allow or deny", p.ID)
+		}
+		if len(p.Actions) == 0 {
+			return nil, fmt.Errorf("policy %s: at least one action is required", p.ID)
+		}
+		for _, c := range p.Conditions {
+			if !knownOperators[c.Operator] {
+				return nil, fmt.Errorf("policy %s: unknown operator %q", p.ID, c.Operator)
+			}
+		}
+	}
+	return &Engine{policies: policies}, nil
+}
+
+// LoadFile reads policies from a JSON file of the form {"policies": [...]}
+func LoadFile(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Policies []Policy `json:"policies"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse policy file: %w", err)
+	}
+	return NewEngine(file.Policies)
+}
+
+// Evaluate implements Evaluator
+func (e *Engine) Evaluate(req Request) Decision {
+	decision := Decision{Effect: NotApplicable}
+	for _, p := range e.policies {
+		if !matchesAction(p.Actions, req.Action) || !matchesConditions(p.Conditions, req, p.Effect) {
+			continue
+		}
+		if p.Effect == Deny {
+			return Decision{Effect: Deny, PolicyID: p.ID}
+		}
+		if decision.Effect == NotApplicable {
+			decision = Decision{Effect: Allow, PolicyID: p.ID}
+		}
+	}
+	return decision
+}
+
+func matchesAction(actions []string, action string) bool {
+	for _, a := range actions {
+		if a == "*" || a == action {
+			return true
+		}
+		if strings.HasSuffix(a, "*") && strings.HasPrefix(action, strings.TrimSuffix(a, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesConditions(conditions []Condition, req Request, effect Effect) bool {
+	for _, c := range conditions {
+		if !c.matches(req, effect) {
+			return false
+		}
+	}
+	return true
+}
+
+var knownOperators = map[string]bool{
+	"eq": true, "neq": true, "in": true, "contains": true, "exists": true, "not_exists": true,
+}
+
+// matches reports whether the condition holds. When an attribute it compares
+// is missing, the outcome can't be decided, so conditions fail closed: they
+// hold in deny policies and do not hold in allow policies.
+func (c Condition) matches(req Request, effect Effect) bool {
+	actual, found := lookup(req, c.Attribute)
+
+	switch c.Operator {
+	case "exists":
+		return found
+	case "not_exists":
+		return !found
+	}
+
+	expected, expectedFound := c.Value, true
+	if c.ValueFrom != "" {
+		expected, expectedFound = lookup(req, c.ValueFrom)
+	}
+	if !found || !expectedFound {
+		return effect == Deny
+	}
+
+	switch c.Operator {
+	case "eq":
+		return equal(actual, expected)
+	case "neq":
+		return !equal(actual, expected)
+	case "in":
+		return listContains(expected, actual)
+	case "contains":
+		return listContains(actual, expected)
+	}
+	return false
+}
+
+// lookup resolves "subject.x", "resource.x" or "action"
+func lookup(req Request, path string) (interface{}, bool) {
+	if path == "action" {
+		return req.Action, true
+	}
+	scope, name, ok := strings.Cut(path, ".")
+	if !ok {
+		return nil, false
+	}
+
+	var attrs Attributes
+	switch scope {
+	case "subject":
+		attrs = req.Subject
+	case "resource":
+		attrs = req.Resource
+	default:
+		return nil, false
+	}
+	value, found := attrs[name]
+	if !found || value == nil || value == "" {
+		return nil, false
+	}
+	return value, true
+}
+
+// equal compares scalars by their string form so JSON numbers match Go ints
+func equal(a, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func listContains(list, value interface{}) bool {
+	switch items := list.(type) {
+	case []string:
+		for _, item := range items {
+			if equal(item, value) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range items {
+			if equal(item, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
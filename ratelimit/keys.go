@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang_projects/middleware"
+	utils "golang_projects/utility"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// KeyFunc returns the key a request is counted under, or false when the rule
+// does not apply to the request
+type KeyFunc func(r *http.Request) (string, bool)
+
+// IPResolver determines the client address of a request. X-Forwarded-For is
+// only believed when the request comes through a trusted proxy; the client is
+// the right-most address that is not a trusted proxy, since a client can put
+// anything on the left of the header.
+type IPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewIPResolver trusts the proxies at the given addresses or CIDR ranges
+func NewIPResolver(proxies []string) (*IPResolver, error) {
+	resolver := &IPResolver{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		resolver.trusted = append(resolver.trusted, network)
+	}
+	return resolver, nil
+}
+
+// ClientIP returns the address of the client that made the request
+func (res *IPResolver) ClientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if res == nil || !res.isTrusted(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			// A malformed entry cannot be trusted, nor anything to its left
+			return ip
+		}
+		ip = hop
+		if !res.isTrusted(hop) {
+			return hop
+		}
+	}
+	return ip
+}
+
+func (res *IPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range res.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP strips the port from a connection's remote address
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// ByIP keys requests by client address
+func ByIP(res *IPResolver) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return "ip:" + res.ClientIP(r), true
+	}
+}
+
+// maxKeyBodySize bounds how much of a request body ByField reads
+const maxKeyBodySize = 1 << 20
+
+// fieldNormalizers bring a field's spellings of the same value to one key.
+// Other fields are trimmed and lower-cased.
+var fieldNormalizers = map[string]func(string) string{
+	// "+1 (555) 010-0000" and "+15550100000" text the same number
+	"phone": utils.NormalizePhone,
+}
+
+// ByField keys requests by a field of the JSON or form request body, such as
+// the email address being signed in to. The value is normalized the way the
+// handlers do (see fieldNormalizers), so reformatting it does not start a new
+// budget. The body is restored for the handler. Requests without the field
+// are not counted.
+func ByField(field string) KeyFunc {
+	normalize, ok := fieldNormalizers[field]
+	if !ok {
+		normalize = func(value string) string { return strings.ToLower(strings.TrimSpace(value)) }
+	}
+
+	return func(r *http.Request) (string, bool) {
+		if r.Body == nil {
+			return "", false
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxKeyBodySize))
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return "", false
+		}
+
+		// Handlers decode JSON whatever the Content-Type, so try JSON first
+		var value string
+		var fields map[string]interface{}
+		if json.Unmarshal(body, &fields) == nil {
+			value, _ = fields[field].(string)
+		} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			if err := r.ParseForm(); err == nil {
+				value = r.PostForm.Get(field)
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		value = normalize(value)
+		if value == "" {
+			return "", false
+		}
+		return field + ":" + value, true
+	}
+}
+
+// ByUser keys requests by the signed-in user. Rate limits run before the
+// route's own authentication, so the credentials are checked here as well;
+// requests without valid user credentials are not counted.
+func ByUser() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok {
+			var err error
+			if principal, err = middleware.Authenticate(r); err != nil {
+				return "", false
+			}
+		}
+		if !principal.IsUser() {
+			return "", false
+		}
+		return "user:" + strconv.Itoa(principal.UserID), true
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIPResolverTrustedProxies(t *testing.T) {
+	resolver, err := NewIPResolver([]string{"10.0.0.0/8", " 192.0.2.1 ", "2001:db8::1", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		resolver   *IPResolver
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no trusted proxies", nil, "203.0.113.5:4000", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"untrusted peer", resolver, "203.0.113.5:4000", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"trusted proxy", resolver, "10.0.0.1:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy without header", resolver, "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"spoofed left entries", resolver, "10.0.0.1:4000", []string{"6.6.6.6, 198.51.100.7"}, "198.51.100.7"},
+		{"chain of trusted proxies", resolver, "192.0.2.1:4000", []string{"6.6.6.6, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"repeated headers", resolver, "10.0.0.1:4000", []string{"6.6.6.6", "198.51.100.7"}, "198.51.100.7"},
+		{"malformed entry", resolver, "10.0.0.1:4000", []string{"198.51.100.7, not-an-ip"}, "10.0.0.1"},
+		{"only trusted hops", resolver, "10.0.0.1:4000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"ipv6 proxy", resolver, "[2001:db8::1]:4000", []string{"2001:db8::99"}, "2001:db8::99"},
+		{"single address is not a range", resolver, "192.0.2.2:4000", []string{"198.51.100.7"}, "192.0.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := tt.resolver.ClientIP(r); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIPResolverRejectsInvalidProxies(t *testing.T) {
+	if _, err := NewIPResolver([]string{"10.0.0.0/99"}); err == nil {
+		t.Fatal("invalid CIDR accepted")
+	}
+	if _, err := NewIPResolver([]string{"proxy.internal"}); err == nil {
+		t.Fatal("host name accepted")
+	}
+}
+
+func TestByFieldNormalizesValues(t *testing.T) {
+	keyOf := func(field, contentType, body string) (string, bool) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		key, ok := ByField(field)(r)
+
+		// The handler still sees the whole body
+		if restored, _ := io.ReadAll(r.Body); string(restored) != body {
+			t.Fatalf("body not restored: %q", restored)
+		}
+		return key, ok
+	}
+
+	tests := []struct {
+		name, field, contentType, body, want string
+	}{
+		{"formatted phone", "phone", "application/json", `{"phone": "+1 (555) 010-0000"}`, "phone:+15550100000"},
+		{"plain phone", "phone", "application/json", `{"phone": "+15550100000"}`, "phone:+15550100000"},
+		{"dotted phone in a form", "phone", "application/x-www-form-urlencoded", "phone=%2B1.555.010.0000", "phone:+15550100000"},
+		{"email", "email", "application/json", `{"email": " Alice@Example.com "}`, "email:alice@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := keyOf(tt.field, tt.contentType, tt.body)
+			if !ok || key != tt.want {
+				t.Fatalf("got %q (%v), want %q", key, ok, tt.want)
+			}
+		})
+	}
+
+	for _, body := range []string{`{}`, `{"phone": "()-"}`, `not json`} {
+		if key, ok := keyOf("phone", "application/json", body); ok {
+			t.Fatalf("%s: counted under %q", body, key)
+		}
+	}
+}
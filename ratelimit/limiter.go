@@ -0,0 +1,98 @@
+// Package ratelimit throttles requests with a sliding window counter kept in
+// a pluggable Store.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Rule is one request budget: at most Limit requests per Window for each key
+// returned by Key. Requests Key returns no key for are not counted.
+type Rule struct {
+	// Name identifies the rule in store keys; rules sharing a name share budgets
+	Name   string
+	Limit  int
+	Window time.Duration
+	Key    KeyFunc
+}
+
+// Policy returns the rule in RateLimit-Policy header syntax, e.g. "10;w=60"
+func (r Rule) Policy() string {
+	return fmt.Sprintf("%d;w=%d", r.Limit, int(r.Window.Seconds()))
+}
+
+// Result is the outcome of counting one request against a rule
+type Result struct {
+	Rule      Rule
+	Allowed   bool
+	Remaining int
+	// Reset is when the budget is fully restored if no more requests are made
+	Reset time.Duration
+	// RetryAfter is how long a rejected client should wait before retrying
+	RetryAfter time.Duration
+}
+
+// Limiter counts requests with the sliding window counter algorithm: the
+// count of the previous fixed window is weighted by how much of it still
+// overlaps the sliding window and added to the count of the current one.
+type Limiter struct {
+	Store Store
+	// Now returns the current time; nil means time.Now
+	Now func() time.Time
+}
+
+// Allow counts one request for key against the rule. Rejected requests are
+// counted too, so a client that keeps retrying stays limited.
+func (l *Limiter) Allow(ctx context.Context, rule Rule, key string) (Result, error) {
+	now := time.Now()
+	if l.Now != nil {
+		now = l.Now()
+	}
+
+	window := rule.Window.Nanoseconds()
+	current := now.UnixNano() / window
+	elapsed := float64(now.UnixNano()%window) / float64(window)
+
+	base := rule.Name + ":" + key + ":"
+	count, err := l.Store.Increment(ctx, fmt.Sprintf("%s%d", base, current), 1, 2*rule.Window)
+	if err != nil {
+		return Result{}, err
+	}
+	previous, err := l.Store.Get(ctx, fmt.Sprintf("%s%d", base, current-1))
+	if err != nil {
+		return Result{}, err
+	}
+
+	limit := float64(rule.Limit)
+	weighted := float64(previous)*(1-elapsed) + float64(count)
+	result := Result{
+		Rule:      rule,
+		Allowed:   weighted <= limit,
+		Remaining: int(math.Max(0, math.Floor(limit-weighted))),
+		Reset:     time.Duration((2 - elapsed) * float64(rule.Window)),
+	}
+	if !result.Allowed {
+		result.RetryAfter = retryAfter(rule.Window, elapsed, float64(previous), float64(count), limit)
+	}
+	return result, nil
+}
+
+// retryAfter returns how long until the weighted count drops below the limit,
+// leaving room for one more request
+func retryAfter(window time.Duration, elapsed, previous, count, limit float64) time.Duration {
+	// Within the current window only the previous window's weight decreases
+	if count+1 <= limit && previous > 0 {
+		if fraction := 1 - (limit-count-1)/previous; fraction > elapsed {
+			return time.Duration((fraction - elapsed) * float64(window))
+		}
+	}
+	// Otherwise wait until the current window's weight has decayed in the next one
+	fraction := 1 - (limit-1)/count
+	if fraction < 0 {
+		fraction = 0
+	}
+	return time.Duration((1 - elapsed + fraction) * float64(window))
+}
@@ -0,0 +1,160 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testClock is a settable time source for Limiter.Now
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+// newTestLimiter returns a limiter with an in-memory store whose clock starts
+// at the beginning of a window of the given length
+func newTestLimiter(window time.Duration) (*Limiter, *testClock) {
+	clock := &testClock{now: time.Unix(0, 0).Add(1000 * window)}
+	return &Limiter{Store: NewMemoryStore(), Now: clock.Now}, clock
+}
+
+func TestLimiterSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	rule := Rule{Name: "test", Limit: 10, Window: time.Minute}
+	limiter, clock := newTestLimiter(rule.Window)
+
+	for i := 1; i <= rule.Limit; i++ {
+		result, err := limiter.Allow(ctx, rule, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != rule.Limit-i {
+			t.Fatalf("request %d: allowed=%v remaining=%d", i, result.Allowed, result.Remaining)
+		}
+	}
+	result, _ := limiter.Allow(ctx, rule, "k")
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("request over the limit: allowed=%v remaining=%d", result.Allowed, result.Remaining)
+	}
+
+	// Other keys have their own budget
+	if result, _ := limiter.Allow(ctx, rule, "other"); !result.Allowed {
+		t.Fatal("another key was limited")
+	}
+
+	// Half way through the next window, half of the previous 11 requests
+	// still count: 5.5 + 1 leaves room for 3 more
+	clock.now = clock.now.Add(rule.Window + rule.Window/2)
+	result, _ = limiter.Allow(ctx, rule, "k")
+	if !result.Allowed || result.Remaining != 3 {
+		t.Fatalf("next window: allowed=%v remaining=%d", result.Allowed, result.Remaining)
+	}
+	if result.Reset != rule.Window+rule.Window/2 {
+		t.Fatalf("reset: got %s, want %s", result.Reset, rule.Window+rule.Window/2)
+	}
+	for i := 0; i < 3; i++ {
+		limiter.Allow(ctx, rule, "k")
+	}
+	if result, _ := limiter.Allow(ctx, rule, "k"); result.Allowed {
+		t.Fatal("weighted count over the limit was allowed")
+	}
+
+	// Two windows later nothing counts any more
+	clock.now = clock.now.Add(2 * rule.Window)
+	if result, _ := limiter.Allow(ctx, rule, "k"); !result.Allowed || result.Remaining != rule.Limit-1 {
+		t.Fatalf("after two windows: allowed=%v remaining=%d", result.Allowed, result.Remaining)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	window := time.Minute
+	tests := []struct {
+		name                            string
+		elapsed, previous, count, limit float64
+		want                            time.Duration
+	}{
+		// 10*(1-0.6) + 5 + 1 = 10 once 60% of the window has passed
+		{"previous window decays", 0.5, 10, 5, 10, 6 * time.Second},
+		// 11*(1-f) + 1 <= 10 from f = 2/11 of the next window
+		{"current window over the limit", 0.25, 0, 11, 10, 55909090909 * time.Nanosecond},
+		// nothing of the current window may remain: wait for the window after next
+		{"limit of one", 0.5, 0, 3, 1, 90 * time.Second},
+		// the current window alone is at the limit: 10*(1-f) + 1 <= 10 from f = 0.1 of the next window
+		{"current window at the limit", 0.9, 2, 10, 10, 12 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryAfter(window, tt.elapsed, tt.previous, tt.count, tt.limit)
+			if diff := got - tt.want; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfterIsEnough(t *testing.T) {
+	ctx := context.Background()
+	rule := Rule{Name: "test", Limit: 5, Window: time.Minute}
+
+	// Reject at various points of a window, after traffic in the previous one
+	for _, offset := range []time.Duration{0, 10 * time.Second, 30 * time.Second, 59 * time.Second} {
+		for _, previous := range []int{0, 3, 5, 12} {
+			limiter, clock := newTestLimiter(rule.Window)
+			for i := 0; i < previous; i++ {
+				limiter.Allow(ctx, rule, "k")
+			}
+			clock.now = clock.now.Add(rule.Window + offset)
+
+			var result Result
+			for i := 0; i < 20; i++ {
+				if result, _ = limiter.Allow(ctx, rule, "k"); !result.Allowed {
+					break
+				}
+			}
+			if result.Allowed {
+				t.Fatalf("offset %s, previous %d: never rejected", offset, previous)
+			}
+			if result.RetryAfter <= 0 || result.RetryAfter > 2*rule.Window {
+				t.Fatalf("offset %s, previous %d: retry after %s", offset, previous, result.RetryAfter)
+			}
+
+			clock.now = clock.now.Add(result.RetryAfter + time.Millisecond)
+			if retry, _ := limiter.Allow(ctx, rule, "k"); !retry.Allowed {
+				t.Fatalf("offset %s, previous %d: rejected again after waiting %s", offset, previous, result.RetryAfter)
+			}
+		}
+	}
+}
+
+// recordingRedis records the commands sent to it and replies with reply
+type recordingRedis struct {
+	commands [][]interface{}
+	reply    interface{}
+}
+
+func (c *recordingRedis) Do(_ context.Context, args ...interface{}) (interface{}, error) {
+	c.commands = append(c.commands, args)
+	return c.reply, nil
+}
+
+func TestRedisStoreIncrementIsOneCommand(t *testing.T) {
+	client := &recordingRedis{reply: int64(3)}
+	store := RedisStore{Client: client, Prefix: "rl:"}
+
+	value, err := store.Increment(context.Background(), "login:ip:1", 1, 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 3 {
+		t.Fatalf("got %d, want 3", value)
+	}
+	if len(client.commands) != 1 {
+		t.Fatalf("got %d commands, want 1: %v", len(client.commands), client.commands)
+	}
+	cmd := client.commands[0]
+	if cmd[0] != "EVAL" || cmd[2] != 1 || cmd[3] != "rl:login:ip:1" || cmd[4] != int64(1) || cmd[5] != int64(120000) {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+}
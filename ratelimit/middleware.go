@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	utils "golang_projects/utility"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler counts each request against every rule that applies to it. A
+// request exceeding any rule is rejected with 429 Too Many Requests. The
+// RateLimit headers describe the rule with the least remaining budget. If the
+// store fails the request is let through, so an outage of a shared store does
+// not take the API down with it.
+func (l *Limiter) Handler(rules []Rule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			tightest *Result
+			rejected *Result
+			policies []string
+		)
+		for _, rule := range rules {
+			key, ok := rule.Key(r)
+			if !ok {
+				continue
+			}
+			result, err := l.Allow(r.Context(), rule, key)
+			if err != nil {
+				log.Printf("Rate limit store error: %v", err)
+				continue
+			}
+			policies = append(policies, rule.Policy())
+
+			if tightest == nil || result.Remaining < tightest.Remaining {
+				tightest = &result
+			}
+			if !result.Allowed && (rejected == nil || result.RetryAfter > rejected.RetryAfter) {
+				rejected = &result
+			}
+		}
+
+		if rejected != nil {
+			tightest = rejected
+		}
+		if tightest != nil {
+			h := w.Header()
+			h.Set("RateLimit-Policy", strings.Join(policies, ", "))
+			h.Set("RateLimit-Limit", strconv.Itoa(tightest.Rule.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(seconds(tightest.Reset)))
+		}
+
+		if rejected != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(rejected.RetryAfter)))
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, false, "Too many requests; try again later", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seconds rounds a duration up to whole seconds for the rate limit headers
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
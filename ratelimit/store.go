@@ -0,0 +1,150 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store keeps the request counters. Implementations must make Increment
+// atomic, since several server instances may share one store.
+type Store interface {
+	// Increment adds n to the counter at key and returns the new value. A new
+	// counter expires after ttl.
+	Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Get returns the counter at key, 0 if it does not exist or has expired.
+	Get(ctx context.Context, key string) (int64, error)
+}
+
+// MemoryStore is a Store for a single server instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]memoryCounter
+	lastSweep time.Time
+}
+
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// memorySweepInterval is how often expired counters are removed
+const memorySweepInterval = time.Minute
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]memoryCounter)}
+}
+
+// Increment implements Store
+func (s *MemoryStore) Increment(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expiresAt) {
+		c = memoryCounter{expiresAt: now.Add(ttl)}
+	}
+	c.value += n
+	s.counters[key] = c
+	return c.value, nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || !time.Now().Before(c.expiresAt) {
+		return 0, nil
+	}
+	return c.value, nil
+}
+
+// sweep drops expired counters so that the map does not grow without bound
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < memorySweepInterval {
+		return
+	}
+	for key, c := range s.counters {
+		if !now.Before(c.expiresAt) {
+			delete(s.counters, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// RedisClient is the subset of a Redis client used by RedisStore. Do sends one
+// command, such as Do(ctx, "INCRBY", key, 1), and returns its reply; a missing
+// key replies nil. Clients such as go-redis are adapted with a one-line
+// function, e.g. func(ctx, args...) { return rdb.Do(ctx, args...).Result() }
+// with redis.Nil mapped to a nil reply.
+type RedisClient interface {
+	Do(ctx context.Context, args ...interface{}) (interface{}, error)
+}
+
+// RedisClientFunc adapts a function to RedisClient
+type RedisClientFunc func(ctx context.Context, args ...interface{}) (interface{}, error)
+
+// Do implements RedisClient
+func (f RedisClientFunc) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	return f(ctx, args...)
+}
+
+// RedisStore is a Store shared by every server instance connected to the same
+// Redis (or Redis-compatible) server
+type RedisStore struct {
+	Client RedisClient
+	// Prefix namespaces the keys, e.g. "ratelimit:"
+	Prefix string
+}
+
+// redisIncrementScript increments a counter and sets the expiry of a new one
+// in a single step, so a counter never outlives its window for want of a
+// PEXPIRE that did not run
+const redisIncrementScript = `local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if value == tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value`
+
+// Increment implements Store
+func (s RedisStore) Increment(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	reply, err := s.Client.Do(ctx, "EVAL", redisIncrementScript, 1, s.Prefix+key, n, ttl.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	return redisInt(reply)
+}
+
+// Get implements Store
+func (s RedisStore) Get(ctx context.Context, key string) (int64, error) {
+	reply, err := s.Client.Do(ctx, "GET", s.Prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	if reply == nil {
+		return 0, nil
+	}
+	return redisInt(reply)
+}
+
+// redisInt converts an integer or bulk string reply to an int64
+func redisInt(reply interface{}) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	default:
+		return 0, fmt.Errorf("unexpected redis reply %T", reply)
+	}
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"database/sql"
+	model "golang_projects/model"
+	"strings"
+	"time"
+)
+
+// apiKeyLastUsedResolution limits how often last_used_at is written for a busy key
+const apiKeyLastUsedResolution = time.Minute
+
+// CreateAPIKey stores a new (hashed) API key and returns its id
+func CreateAPIKey(db *sql.DB, key model.APIKey) (int, error) {
+	res, err := db.Exec(`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, nullIfEmpty(strings.Join(key.Scopes, " ")), utcOrNil(key.ExpiresAt))
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its public prefix
+func GetAPIKeyByPrefix(db *sql.DB, prefix string) (model.APIKey, error) {
+	row := db.QueryRow(`SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+	          FROM api_keys WHERE prefix = ?`, prefix)
+	return scanAPIKey(row)
+}
+
+// GetAPIKeyByID retrieves one of a user's API keys
+func GetAPIKeyByID(db *sql.DB, userID, id int) (model.APIKey, error) {
+	row := db.QueryRow(`SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+	          FROM api_keys WHERE id = ? AND user_id = ?`, id, userID)
+	return scanAPIKey(row)
+}
+
+// GetAPIKeysByUser lists a user's API keys, newest first
+func GetAPIKeysByUser(db *sql.DB, userID int) ([]model.APIKey, error) {
+	rows, err := db.Query(`SELECT id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at, created_at
+	          FROM api_keys WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of a user's API keys. sql.ErrNoRows is returned
+// when the user has no such active key.
+func RevokeAPIKey(db *sql.DB, userID, id int) error {
+	res, err := db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIKey records that a key was used. Writes are coalesced to once per
+// apiKeyLastUsedResolution so busy keys do not write on every request.
+func TouchAPIKey(db *sql.DB, id int) error {
+	now := time.Now().UTC()
+	_, err := db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		now, id, now.Add(-apiKeyLastUsedResolution))
+	return err
+}
+
+func scanAPIKey(s scanner) (model.APIKey, error) {
+	var (
+		key                            model.APIKey
+		scopes                         sql.NullString
+		expiresAt, lastUsed, revokedAt sql.NullTime
+	)
+	err := s.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes,
+		&expiresAt, &lastUsed, &revokedAt, &key.CreatedAt)
+	if err != nil {
+		return key, err
+	}
+	key.Scopes = strings.Fields(scopes.String)
+	key.ExpiresAt = timeOrNil(expiresAt)
+	key.LastUsedAt = timeOrNil(lastUsed)
+	key.RevokedAt = timeOrNil(revokedAt)
+	return key, nil
+}
+
+func utcOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func timeOrNil(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// revokeUserAPIKeys revokes every active API key of the user
+func revokeUserAPIKeys(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("UPDATE api_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), userID)
+	return err
+}
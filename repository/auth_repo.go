@@ -2,9 +2,11 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	model "golang_projects/model"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-sqlite3"
 )
@@ -27,19 +29,35 @@ func CreateUser(db *sql.DB, user model.User) error {
 // GetUserByEmail retrieves a user by email
 func GetUserByEmail(db *sql.DB, email string) (model.User, error) {
 	var user model.User
-	err := db.QueryRow("SELECT id, name, email, phone, address FROM users WHERE email = ?", email).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address)
+	err := db.QueryRow("SELECT id, name, email, phone, address, COALESCE(region, ''), email_verified_at IS NOT NULL, COALESCE(pending_email, ''), phone_verified_at IS NOT NULL FROM users WHERE email = ?", email).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address, &user.Region, &user.EmailVerified, &user.PendingEmail, &user.PhoneVerified)
+	return user, err
+}
+
+// GetUserByID retrieves a user by id
+func GetUserByID(db *sql.DB, userID int) (model.User, error) {
+	var user model.User
+	err := db.QueryRow("SELECT id, name, email, phone, address, COALESCE(region, ''), email_verified_at IS NOT NULL, COALESCE(pending_email, ''), phone_verified_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address, &user.Region, &user.EmailVerified, &user.PendingEmail, &user.PhoneVerified)
+	return user, err
+}
+
+// GetUserByPendingEmail retrieves the user waiting to verify a change to the given address
+func GetUserByPendingEmail(db *sql.DB, email string) (model.User, error) {
+	var user model.User
+	err := db.QueryRow("SELECT id, name, email, phone, address, COALESCE(region, ''), email_verified_at IS NOT NULL, pending_email, phone_verified_at IS NOT NULL FROM users WHERE pending_email = ?", email).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address, &user.Region, &user.EmailVerified, &user.PendingEmail, &user.PhoneVerified)
 	return user, err
 }
 
 func GetUserLogin(db *sql.DB, email string) (model.User, error) {
 	var user model.User
-	err := db.QueryRow("SELECT id, name, email, password, phone, address FROM users WHERE email = ?", email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Phone, &user.Address)
+	var lockedUntil sql.NullTime
+	err := db.QueryRow("SELECT id, name, email, password, phone, address, email_verified_at IS NOT NULL, phone_verified_at IS NOT NULL, locked_until FROM users WHERE email = ?", email).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Phone, &user.Address, &user.EmailVerified, &user.PhoneVerified, &lockedUntil)
+	user.LockedUntil = timeOrNil(lockedUntil)
 	return user, err
 }
 
 // GetAllUsers retrieves all users from the database
 func GetAllUsers(db *sql.DB) ([]model.User, error) {
-	rows, err := db.Query("SELECT id, name, email, phone, address FROM users")
+	rows, err := db.Query("SELECT id, name, email, phone, address, COALESCE(region, ''), email_verified_at IS NOT NULL, phone_verified_at IS NOT NULL FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +66,7 @@ func GetAllUsers(db *sql.DB) ([]model.User, error) {
 	var users []model.User
 	for rows.Next() {
 		var user model.User
-		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address)
+		err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address, &user.Region, &user.EmailVerified, &user.PhoneVerified)
 		if err != nil {
 			return nil, err
 		}
@@ -59,6 +77,51 @@ func GetAllUsers(db *sql.DB) ([]model.User, error) {
 
 // UpdateUserByID updates the user fields in the database based on the provided map
 func UpdateUserByID(db *sql.DB, userID int, updateFields map[string]interface{}) (int64, error) {
+	query, args := updateUserStatement(userID, updateFields)
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// UpdateUserPasswordByID updates the user fields, which include a new
+// password hash, and moves the replaced hash to the password history in the
+// same transaction, keeping the newest keepHistory hashes
+func UpdateUserPasswordByID(db *sql.DB, userID int, updateFields map[string]interface{}, keepHistory int) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var oldHash string
+	err = tx.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&oldHash)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	query, args := updateUserStatement(userID, updateFields)
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := addPasswordHistory(tx, userID, oldHash, keepHistory); err != nil {
+		return 0, err
+	}
+	return rows, tx.Commit()
+}
+
+// updateUserStatement builds the UPDATE statement for the given fields and its arguments
+func updateUserStatement(userID int, updateFields map[string]interface{}) (string, []interface{}) {
 	setClauses := []string{}
 	args := []interface{}{}
 
@@ -68,9 +131,11 @@ func UpdateUserByID(db *sql.DB, userID int, updateFields map[string]interface{})
 	}
 	args = append(args, userID)
 
-	query := fmt.Sprintf("UPDATE users SET %s WHERE id = ?", strings.Join(setClauses, ", "))
+	return fmt.Sprintf("UPDATE users SET %s WHERE id = ?", strings.Join(setClauses, ", ")), args
+}
 
-	res, err := db.Exec(query, args...)
+func DeleteUserByID(db *sql.DB, userID int) (int64, error) {
+	res, err := db.Exec("DELETE FROM users WHERE id = ?", userID)
 	if err != nil {
 		return 0, err
 	}
@@ -78,11 +143,87 @@ func UpdateUserByID(db *sql.DB, userID int, updateFields map[string]interface{})
 	return res.RowsAffected()
 }
 
-func DeleteUserByID(db *sql.DB, userID int) (int64, error) {
-	res, err := db.Exec("DELETE FROM users WHERE id = ?", userID)
+// ErrEmailTaken is returned by MarkEmailVerified when a pending address was
+// claimed by another account before its owner verified it
+var ErrEmailTaken = errors.New("email already exists")
+
+// MarkEmailVerified records that the user proved ownership of their email
+// address. A pending address replaces the current one, which stays in use
+// until then. It returns false when the address is neither pending nor the
+// user's unverified address (it was changed after the link was sent) or was
+// already verified.
+func MarkEmailVerified(db *sql.DB, userID int, email string) (bool, error) {
+	res, err := db.Exec(`UPDATE users SET email = ?, email_verified_at = ?,
+	          pending_email = CASE WHEN pending_email = ? THEN NULL ELSE pending_email END
+	          WHERE id = ? AND (pending_email = ? OR (email = ? AND email_verified_at IS NULL))`,
+		email, time.Now().UTC(), email, userID, email, email)
 	if err != nil {
-		return 0, err
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return false, ErrEmailTaken
+		}
+		return false, err
 	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
 
-	return res.RowsAffected()
+// RecordFailedLogin counts a wrong password against the account. Once
+// threshold failures have accumulated the account is locked until
+// lockedUntil and the count starts over; the returned bool reports whether
+// this failure locked it.
+func RecordFailedLogin(db *sql.DB, userID, threshold int, lockedUntil time.Time) (bool, error) {
+	var attempts int
+	err := db.QueryRow(`UPDATE users SET
+	          failed_login_attempts = CASE WHEN failed_login_attempts + 1 >= ? THEN 0 ELSE failed_login_attempts + 1 END,
+	          locked_until = CASE WHEN failed_login_attempts + 1 >= ? THEN ? ELSE locked_until END
+	          WHERE id = ? RETURNING failed_login_attempts`, threshold, threshold, lockedUntil.UTC(), userID).Scan(&attempts)
+	if err != nil {
+		return false, err
+	}
+	return attempts == 0, nil
+}
+
+// ResetFailedLogins clears the failed login count after a successful sign-in
+func ResetFailedLogins(db *sql.DB, userID int) error {
+	_, err := db.Exec("UPDATE users SET failed_login_attempts = 0 WHERE id = ? AND failed_login_attempts > 0", userID)
+	return err
+}
+
+// RecordFailedSecondFactor counts a wrong second-factor code against the
+// account and locks it like RecordFailedLogin. The count is per user and is
+// not cleared by a correct password or a new MFA challenge.
+func RecordFailedSecondFactor(db *sql.DB, userID, threshold int, lockedUntil time.Time) (bool, error) {
+	var attempts int
+	err := db.QueryRow(`UPDATE users SET
+	          failed_mfa_attempts = CASE WHEN failed_mfa_attempts + 1 >= ? THEN 0 ELSE failed_mfa_attempts + 1 END,
+	          locked_until = CASE WHEN failed_mfa_attempts + 1 >= ? THEN ? ELSE locked_until END
+	          WHERE id = ? RETURNING failed_mfa_attempts`, threshold, threshold, lockedUntil.UTC(), userID).Scan(&attempts)
+	if err != nil {
+		return false, err
+	}
+	return attempts == 0, nil
+}
+
+// ResetFailedSecondFactors clears the wrong second-factor count after a second factor was verified
+func ResetFailedSecondFactors(db *sql.DB, userID int) error {
+	_, err := db.Exec("UPDATE users SET failed_mfa_attempts = 0 WHERE id = ? AND failed_mfa_attempts > 0", userID)
+	return err
+}
+
+// GetLockedUntil returns when the user's lockout ends, or nil when the user was never locked
+func GetLockedUntil(db *sql.DB, userID int) (*time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow("SELECT locked_until FROM users WHERE id = ?", userID).Scan(&lockedUntil)
+	return timeOrNil(lockedUntil), err
+}
+
+// UnlockUser lifts a lockout and clears the failed login counts. It reports
+// whether the user exists.
+func UnlockUser(db *sql.DB, userID int) (bool, error) {
+	res, err := db.Exec("UPDATE users SET failed_login_attempts = 0, failed_mfa_attempts = 0, locked_until = NULL WHERE id = ?", userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
 }
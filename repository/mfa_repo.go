@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	model "golang_projects/model"
+	"time"
+)
+
+// Errors returned by the MFA challenge functions
+var (
+	ErrMFAChallengeUsed      = errors.New("mfa challenge already used")
+	ErrMFAChallengeExhausted = errors.New("mfa challenge has no attempts left")
+)
+
+// GetUserMFA retrieves a user's MFA enrollment; sql.ErrNoRows means none
+func GetUserMFA(db *sql.DB, userID int) (model.UserMFA, error) {
+	var mfa model.UserMFA
+	err := db.QueryRow(`SELECT user_id, totp_secret, totp_enabled_at, totp_last_step, created_at
+	          FROM user_mfa WHERE user_id = ?`, userID).
+		Scan(&mfa.UserID, &mfa.TOTPSecret, &mfa.TOTPEnabledAt, &mfa.TOTPLastStep, &mfa.CreatedAt)
+	return mfa, err
+}
+
+// SavePendingTOTPSecret starts (or restarts) TOTP enrollment with a new
+// secret. It does nothing and returns false when TOTP is already enabled.
+func SavePendingTOTPSecret(db *sql.DB, userID int, secret string) (bool, error) {
+	res, err := db.Exec(`INSERT INTO user_mfa (user_id, totp_secret) VALUES (?, ?)
+	          ON CONFLICT(user_id) DO UPDATE SET totp_secret = excluded.totp_secret, totp_last_step = 0
+	          WHERE user_mfa.totp_enabled_at IS NULL`, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// GetTOTPSecrets returns every stored TOTP secret by user id
+func GetTOTPSecrets(db *sql.DB) (map[int]string, error) {
+	rows, err := db.Query("SELECT user_id, totp_secret FROM user_mfa")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := make(map[int]string)
+	for rows.Next() {
+		var userID int
+		var secret string
+		if err := rows.Scan(&userID, &secret); err != nil {
+			return nil, err
+		}
+		secrets[userID] = secret
+	}
+	return secrets, rows.Err()
+}
+
+// UpdateTOTPSecret replaces a stored TOTP secret, e.g. to encrypt a legacy plaintext secret
+func UpdateTOTPSecret(db *sql.DB, userID int, secret string) error {
+	_, err := db.Exec("UPDATE user_mfa SET totp_secret = ? WHERE user_id = ?", secret, userID)
+	return err
+}
+
+// EnableTOTP marks a pending enrollment as confirmed
+func EnableTOTP(db *sql.DB, userID int) error {
+	_, err := db.Exec("UPDATE user_mfa SET totp_enabled_at = ? WHERE user_id = ? AND totp_enabled_at IS NULL",
+		time.Now().UTC(), userID)
+	return err
+}
+
+// DisableTOTP removes a user's TOTP enrollment
+func DisableTOTP(db *sql.DB, userID int) error {
+	_, err := db.Exec("DELETE FROM user_mfa WHERE user_id = ?", userID)
+	return err
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false
+// when a code from that step (or a later one) was already used.
+func UseTOTPStep(db *sql.DB, userID int, step int64) (bool, error) {
+	res, err := db.Exec("UPDATE user_mfa SET totp_last_step = ? WHERE user_id = ? AND totp_last_step < ?",
+		step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// CreateMFAChallenge stores a new (hashed) MFA challenge token
+func CreateMFAChallenge(db *sql.DB, challenge model.MFAChallenge) error {
+	_, err := db.Exec("INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		challenge.TokenHash, challenge.UserID, challenge.ExpiresAt.UTC())
+	return err
+}
+
+// GetMFAChallengeByHash retrieves an MFA challenge by the hash of its token
+func GetMFAChallengeByHash(db *sql.DB, tokenHash string) (model.MFAChallenge, error) {
+	var c model.MFAChallenge
+	err := db.QueryRow(`SELECT id, token_hash, user_id, expires_at, attempts, used_at, created_at
+	          FROM mfa_challenges WHERE token_hash = ?`, tokenHash).
+		Scan(&c.ID, &c.TokenHash, &c.UserID, &c.ExpiresAt, &c.Attempts, &c.UsedAt, &c.CreatedAt)
+	return c, err
+}
+
+// ClaimMFAChallengeAttempt uses up one of the challenge's maxAttempts before
+// a code is checked, so parallel guesses cannot exceed the limit. It returns
+// ErrMFAChallengeExhausted when no attempt is left or the challenge is completed.
+func ClaimMFAChallengeAttempt(db *sql.DB, id, maxAttempts int) error {
+	res, err := db.Exec("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ? AND attempts < ? AND used_at IS NULL",
+		id, maxAttempts)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMFAChallengeExhausted
+	}
+	return nil
+}
+
+// ConsumeMFAChallenge marks a challenge as completed so it cannot be used again
+func ConsumeMFAChallenge(db *sql.DB, id int) error {
+	res, err := db.Exec("UPDATE mfa_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMFAChallengeUsed
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes discards a user's recovery codes and stores new (hashed) ones
+func ReplaceRecoveryCodes(db *sql.DB, userID int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes an unused recovery code. It returns false when
+// the user has no such unused code.
+func UseRecoveryCode(db *sql.DB, userID int, codeHash string) (bool, error) {
+	res, err := db.Exec(`UPDATE mfa_recovery_codes SET used_at = ?
+	          WHERE id = (SELECT id FROM mfa_recovery_codes WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1)`,
+		time.Now().UTC(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes a user has left
+func CountRecoveryCodes(db *sql.DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// DeleteRecoveryCodes removes all of a user's recovery codes
+func DeleteRecoveryCodes(db *sql.DB, userID int) error {
+	_, err := db.Exec("DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID)
+	return err
+}
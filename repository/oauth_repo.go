@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	model "golang_projects/model"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrAuthorizationCodeUsed is returned when an authorization code is redeemed twice
+var ErrAuthorizationCodeUsed = errors.New("authorization code already used")
+
+// CreateOAuthClient registers a new OAuth client
+func CreateOAuthClient(db *sql.DB, client model.OAuthClient) error {
+	query := `INSERT INTO oauth_clients (client_id, client_secret_hash, name, redirect_uris, grant_types, scopes)
+	          VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, client.ClientID, nullIfEmpty(client.ClientSecretHash), client.Name,
+		strings.Join(client.RedirectURIs, " "), strings.Join(client.GrantTypes, " "), strings.Join(client.Scopes, " "))
+
+	if err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return fmt.Errorf("client already exists")
+		}
+		return err
+	}
+	return nil
+}
+
+// GetOAuthClient retrieves a client by its client_id
+func GetOAuthClient(db *sql.DB, clientID string) (model.OAuthClient, error) {
+	row := db.QueryRow(`SELECT id, client_id, client_secret_hash, name, redirect_uris, grant_types, scopes, created_at
+	          FROM oauth_clients WHERE client_id = ?`, clientID)
+	return scanOAuthClient(row)
+}
+
+// GetAllOAuthClients retrieves every registered client
+func GetAllOAuthClients(db *sql.DB) ([]model.OAuthClient, error) {
+	rows, err := db.Query(`SELECT id, client_id, client_secret_hash, name, redirect_uris, grant_types, scopes, created_at
+	          FROM oauth_clients ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []model.OAuthClient
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOAuthClient(row scanner) (model.OAuthClient, error) {
+	var (
+		client                           model.OAuthClient
+		secretHash                       sql.NullString
+		redirectURIs, grantTypes, scopes string
+	)
+	err := row.Scan(&client.ID, &client.ClientID, &secretHash, &client.Name, &redirectURIs, &grantTypes, &scopes, &client.CreatedAt)
+	if err != nil {
+		return client, err
+	}
+	client.ClientSecretHash = secretHash.String
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.GrantTypes = strings.Fields(grantTypes)
+	client.Scopes = strings.Fields(scopes)
+	return client, nil
+}
+
+// CreateAuthorizationCode stores a hashed authorization code
+func CreateAuthorizationCode(db *sql.DB, code model.AuthorizationCode) error {
+	query := `INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce,
+	          code_challenge, code_challenge_method, auth_time, expires_at)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.Nonce,
+		code.CodeChallenge, code.CodeChallengeMethod, code.AuthTime.UTC(), code.ExpiresAt.UTC())
+	return err
+}
+
+// ConsumeAuthorizationCode marks a code as used and returns it. A code can only be consumed once.
+func ConsumeAuthorizationCode(db *sql.DB, codeHash string) (model.AuthorizationCode, error) {
+	var code model.AuthorizationCode
+	var nonce sql.NullString
+
+	tx, err := db.Begin()
+	if err != nil {
+		return code, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT id, code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+	          code_challenge_method, auth_time, expires_at, used_at
+	          FROM authorization_codes WHERE code_hash = ?`, codeHash).
+		Scan(&code.ID, &code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, &code.Scope, &nonce,
+			&code.CodeChallenge, &code.CodeChallengeMethod, &code.AuthTime, &code.ExpiresAt, &code.UsedAt)
+	if err != nil {
+		return code, err
+	}
+	code.Nonce = nonce.String
+
+	res, err := tx.Exec("UPDATE authorization_codes SET used_at = ? WHERE id = ? AND used_at IS NULL", time.Now().UTC(), code.ID)
+	if err != nil {
+		return code, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return code, err
+	}
+	if rows == 0 {
+		return code, ErrAuthorizationCodeUsed
+	}
+
+	return code, tx.Commit()
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"database/sql"
+)
+
+// GetPasswordHashes returns the user's current password hash followed by up
+// to previous earlier hashes, newest first
+func GetPasswordHashes(db *sql.DB, userID, previous int) ([]string, error) {
+	var current string
+	if err := db.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&current); err != nil {
+		return nil, err
+	}
+	hashes := []string{current}
+	if previous <= 0 {
+		return hashes, nil
+	}
+
+	rows, err := db.Query(`SELECT password_hash FROM password_history
+	          WHERE user_id = ? ORDER BY id DESC LIMIT ?`, userID, previous)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// addPasswordHistory records a password hash the user no longer uses and
+// keeps only the newest keep hashes
+func addPasswordHistory(tx *sql.Tx, userID int, hash string, keep int) error {
+	if keep > 0 {
+		_, err := tx.Exec("INSERT INTO password_history (user_id, password_hash) VALUES (?, ?)", userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`DELETE FROM password_history WHERE user_id = ? AND id NOT IN
+	          (SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?)`, userID, userID, keep)
+	return err
+}
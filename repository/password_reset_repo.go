@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrPasswordResetTokenInvalid is returned for unknown, expired or already used reset tokens
+var ErrPasswordResetTokenInvalid = errors.New("password reset token is invalid")
+
+// CreatePasswordResetToken stores a hashed reset token. Tokens the user
+// requested earlier and has not used are discarded, so only the latest
+// email works.
+func CreatePasswordResetToken(db *sql.DB, userID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		userID, tokenHash, expiresAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPasswordResetUserID returns the user an unused, unexpired reset token belongs to
+func GetPasswordResetUserID(db *sql.DB, tokenHash string) (int, error) {
+	var userID int
+	err := db.QueryRow(`SELECT user_id FROM password_reset_tokens
+	          WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`, tokenHash, time.Now().UTC()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+	return userID, err
+}
+
+// ResetPassword redeems a reset token, sets the user's new password hash and
+// revokes their sessions in one transaction. The old hash is moved to the
+// password history, which keeps the newest keepHistory hashes. Only one
+// request can redeem a given token.
+func ResetPassword(db *sql.DB, tokenHash, passwordHash string, keepHistory int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var id, userID int
+	err = tx.QueryRow(`SELECT id, user_id FROM password_reset_tokens
+	          WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`, tokenHash, now).Scan(&id, &userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec("UPDATE password_reset_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", now, id)
+	if err != nil {
+		return 0, err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if rows == 0 {
+		return 0, ErrPasswordResetTokenInvalid
+	}
+
+	var oldHash string
+	if err := tx.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&oldHash); err != nil {
+		return 0, err
+	}
+	if err := addPasswordHistory(tx, userID, oldHash, keepHistory); err != nil {
+		return 0, err
+	}
+
+	// Proving control of the mailbox also lifts a lockout
+	_, err = tx.Exec("UPDATE users SET password = ?, failed_login_attempts = 0, locked_until = NULL WHERE id = ?", passwordHash, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := revokeUserSessions(tx, userID); err != nil {
+		return 0, err
+	}
+	// API keys were created by whoever held the old password, so they go too
+	if err := revokeUserAPIKeys(tx, userID); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+// revokeUserSessions signs the user out everywhere: every refresh token is
+// revoked and access tokens issued before the current second are rejected
+func revokeUserSessions(tx *sql.Tx, userID int) error {
+	// Access token iat has one-second resolution. The cutoff is the start of the
+	// current second so that a login straight after the reset is accepted.
+	cutoff := time.Now().UTC().Truncate(time.Second)
+	if _, err := tx.Exec("UPDATE users SET sessions_revoked_at = ? WHERE id = ?", cutoff, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), userID)
+	return err
+}
+
+// SessionsRevokedAt returns the time before which the user's access tokens are
+// no longer accepted; the zero time if their sessions were never revoked
+func SessionsRevokedAt(db *sql.DB, userID int) (time.Time, error) {
+	var revokedAt sql.NullTime
+	err := db.QueryRow("SELECT sessions_revoked_at FROM users WHERE id = ?", userID).Scan(&revokedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return revokedAt.Time, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	model "golang_projects/model"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Errors returned by the phone OTP functions
+var (
+	ErrPhoneOTPUsed           = errors.New("phone otp already used")
+	ErrPhoneOTPExhausted      = errors.New("phone otp has no attempts left")
+	ErrPhoneOTPBudgetExceeded = errors.New("too many wrong phone otps")
+	ErrPhoneInUse             = errors.New("phone number is already verified on another account")
+)
+
+// CreatePhoneOTP stores a new (hashed) code. Only the latest code for a user
+// and purpose works, but earlier codes are kept until keepFor has passed so
+// their wrong attempts still count (see ClaimPhoneOTPAttempt).
+func CreatePhoneOTP(db *sql.DB, otp model.PhoneOTP, keepFor time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM phone_otps WHERE user_id = ? AND created_at < datetime('now', ?)",
+		otp.UserID, sqliteSecondsAgo(keepFor))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO phone_otps (user_id, phone, purpose, code_hash, expires_at) VALUES (?, ?, ?, ?, ?)",
+		otp.UserID, otp.Phone, otp.Purpose, otp.CodeHash, otp.ExpiresAt.UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// countPhoneOTPFailures returns how many wrong codes the user entered within
+// the window, across every code sent to them and every purpose
+func countPhoneOTPFailures(tx *sql.Tx, userID int, window time.Duration) (int, error) {
+	var failures int
+	err := tx.QueryRow("SELECT COALESCE(SUM(attempts), 0) FROM phone_otps WHERE user_id = ? AND created_at >= datetime('now', ?)",
+		userID, sqliteSecondsAgo(window)).Scan(&failures)
+	return failures, err
+}
+
+// sqliteSecondsAgo formats a duration as a SQLite datetime() modifier for
+// comparing with CURRENT_TIMESTAMP columns
+func sqliteSecondsAgo(d time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int64(d.Seconds()))
+}
+
+// GetLatestPhoneOTP retrieves the most recent unused code for a user and purpose
+func GetLatestPhoneOTP(db *sql.DB, userID int, purpose string) (model.PhoneOTP, error) {
+	var otp model.PhoneOTP
+	err := db.QueryRow(`SELECT id, user_id, phone, purpose, code_hash, attempts, expires_at, used_at, created_at
+	          FROM phone_otps WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	          ORDER BY id DESC LIMIT 1`, userID, purpose).
+		Scan(&otp.ID, &otp.UserID, &otp.Phone, &otp.Purpose, &otp.CodeHash, &otp.Attempts, &otp.ExpiresAt, &otp.UsedAt, &otp.CreatedAt)
+	return otp, err
+}
+
+// ClaimPhoneOTPAttempt reserves one of the code's maxAttempts before the code
+// is compared, so parallel guesses cannot exceed the limit. The attempt also
+// counts against the user's budget of wrong codes within the window (none when
+// budget is 0). ConsumePhoneOTP gives the attempt back when the code matches.
+func ClaimPhoneOTPAttempt(db *sql.DB, otp model.PhoneOTP, maxAttempts, budget int, window time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Write first so the transaction holds the write lock before it reads
+	res, err := tx.Exec("UPDATE phone_otps SET attempts = attempts + 1 WHERE id = ? AND attempts < ? AND used_at IS NULL",
+		otp.ID, maxAttempts)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		var used bool
+		if err := tx.QueryRow("SELECT used_at IS NOT NULL FROM phone_otps WHERE id = ?", otp.ID).Scan(&used); err != nil {
+			return err
+		}
+		if used {
+			return ErrPhoneOTPUsed
+		}
+		return ErrPhoneOTPExhausted
+	}
+
+	if budget > 0 {
+		failures, err := countPhoneOTPFailures(tx, otp.UserID, window)
+		if err != nil {
+			return err
+		}
+		if failures > budget {
+			return ErrPhoneOTPBudgetExceeded
+		}
+	}
+	return tx.Commit()
+}
+
+// ConsumePhoneOTP marks a code as used so it cannot be used again, giving back
+// the attempt claimed for the matching guess
+func ConsumePhoneOTP(db *sql.DB, id int) error {
+	res, err := db.Exec("UPDATE phone_otps SET used_at = ?, attempts = attempts - 1 WHERE id = ? AND used_at IS NULL", time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrPhoneOTPUsed
+	}
+	return nil
+}
+
+// MarkPhoneVerified records that the user proved ownership of phone, storing
+// it in normalized form. A number can be verified on one account only.
+func MarkPhoneVerified(db *sql.DB, userID int, phone string) error {
+	_, err := db.Exec("UPDATE users SET phone = ?, phone_verified_at = ? WHERE id = ?", phone, time.Now().UTC(), userID)
+	if err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return ErrPhoneInUse
+		}
+		return err
+	}
+	return nil
+}
+
+// GetUserByVerifiedPhone retrieves the user who verified a (normalized) phone number
+func GetUserByVerifiedPhone(db *sql.DB, phone string) (model.User, error) {
+	var user model.User
+	err := db.QueryRow(`SELECT id, name, email, phone, address, COALESCE(region, ''),
+	          email_verified_at IS NOT NULL, phone_verified_at IS NOT NULL
+	          FROM users WHERE phone = ? AND phone_verified_at IS NOT NULL`, phone).
+		Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.Address, &user.Region, &user.EmailVerified, &user.PhoneVerified)
+	return user, err
+}
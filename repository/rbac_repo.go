@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	model "golang_projects/model"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// GetUserRoles returns the names of the roles assigned to a user
+func GetUserRoles(db *sql.DB, userID int) ([]string, error) {
+	rows, err := db.Query(`SELECT r.name FROM roles r
+	          JOIN user_roles ur ON ur.role_id = r.id
+	          WHERE ur.user_id = ? ORDER BY r.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		roles = append(roles, name)
+	}
+	return roles, rows.Err()
+}
+
+// RolesHavePermission reports whether any of the roles grants the permission
+func RolesHavePermission(db *sql.DB, roles []string, permission string) (bool, error) {
+	if len(roles) == 0 {
+		return false, nil
+	}
+
+	args := []interface{}{permission}
+	placeholders := make([]string, len(roles))
+	for i, role := range roles {
+		placeholders[i] = "?"
+		args = append(args, role)
+	}
+
+	query := fmt.Sprintf(`SELECT 1 FROM role_permissions rp
+	          JOIN roles r ON r.id = rp.role_id
+	          JOIN permissions p ON p.id = rp.permission_id
+	          WHERE p.name = ? AND r.name IN (%s) LIMIT 1`, strings.Join(placeholders, ", "))
+
+	var exists int
+	err := db.QueryRow(query, args...).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetAllRoles retrieves every role with its permissions
+func GetAllRoles(db *sql.DB) ([]model.Role, error) {
+	rows, err := db.Query(`SELECT r.id, r.name, r.description, p.name FROM roles r
+	          LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	          LEFT JOIN permissions p ON p.id = rp.permission_id
+	          ORDER BY r.name, p.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []model.Role
+	for rows.Next() {
+		var role model.Role
+		var permission sql.NullString
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].ID != role.ID {
+			role.Permissions = []string{}
+			roles = append(roles, role)
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// CreateRole adds a new role
+func CreateRole(db *sql.DB, name, description string) error {
+	_, err := db.Exec("INSERT INTO roles (name, description) VALUES (?, ?)", name, description)
+	if err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return fmt.Errorf("role already exists")
+		}
+		return err
+	}
+	return nil
+}
+
+// GetAllPermissions retrieves every permission
+func GetAllPermissions(db *sql.DB) ([]model.Permission, error) {
+	rows, err := db.Query("SELECT id, name, description FROM permissions ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []model.Permission
+	for rows.Next() {
+		var permission model.Permission
+		if err := rows.Scan(&permission.ID, &permission.Name, &permission.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// GrantPermissionToRole links a permission to a role; both must exist
+func GrantPermissionToRole(db *sql.DB, role, permission string) (int64, error) {
+	res, err := db.Exec(`INSERT OR IGNORE INTO role_permissions (role_id, permission_id)
+	          SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = ? AND p.name = ?`, role, permission)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokePermissionFromRole unlinks a permission from a role
+func RevokePermissionFromRole(db *sql.DB, role, permission string) (int64, error) {
+	res, err := db.Exec(`DELETE FROM role_permissions
+	          WHERE role_id = (SELECT id FROM roles WHERE name = ?)
+	          AND permission_id = (SELECT id FROM permissions WHERE name = ?)`, role, permission)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AssignRoleToUser assigns a role to a user; both must exist
+func AssignRoleToUser(db *sql.DB, userID int, role string) (int64, error) {
+	res, err := db.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role_id)
+	          SELECT u.id, r.id FROM users u, roles r WHERE u.id = ? AND r.name = ?`, userID, role)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AssignRoleToUserByEmail assigns a role to the user with the given email
+func AssignRoleToUserByEmail(db *sql.DB, email, role string) (int64, error) {
+	res, err := db.Exec(`INSERT OR IGNORE INTO user_roles (user_id, role_id)
+	          SELECT u.id, r.id FROM users u, roles r WHERE u.email = ? AND r.name = ?`, email, role)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RemoveRoleFromUser removes a role assignment
+func RemoveRoleFromUser(db *sql.DB, userID int, role string) (int64, error) {
+	res, err := db.Exec(`DELETE FROM user_roles
+	          WHERE user_id = ? AND role_id = (SELECT id FROM roles WHERE name = ?)`, userID, role)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
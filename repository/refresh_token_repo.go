@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	model "golang_projects/model"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already rotated is presented again
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// CreateRefreshToken stores a hashed refresh token
+func CreateRefreshToken(db *sql.DB, token model.RefreshToken) error {
+	_, err := db.Exec(`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, client_id, scope)
+	          VALUES (?, ?, ?, ?, ?, ?)`, token.UserID, token.TokenHash, token.FamilyID, token.ExpiresAt.UTC(), token.ClientID, token.Scope)
+	return err
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by its hash
+func GetRefreshTokenByHash(db *sql.DB, tokenHash string) (model.RefreshToken, error) {
+	var token model.RefreshToken
+	err := db.QueryRow(`SELECT id, user_id, token_hash, family_id, expires_at, revoked_at, replaced_by, client_id, scope, created_at
+	          FROM refresh_tokens WHERE token_hash = ?`, tokenHash).
+		Scan(&token.ID, &token.UserID, &token.TokenHash, &token.FamilyID, &token.ExpiresAt, &token.RevokedAt, &token.ReplacedBy, &token.ClientID, &token.Scope, &token.CreatedAt)
+	return token, err
+}
+
+// RotateRefreshToken replaces the current token with a new one in the same family.
+// If the current token was already rotated or revoked, ErrRefreshTokenReused is returned.
+func RotateRefreshToken(db *sql.DB, current model.RefreshToken, newHash string, expiresAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only one request can win the rotation of a given token. Claiming it with the
+	// guarded UPDATE first takes the write lock before anything else is written, so
+	// a concurrent rotation waits on busy_timeout and then sees zero rows affected.
+	now := time.Now().UTC()
+	res, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = ?
+	          WHERE id = ? AND revoked_at IS NULL`, now, current.ID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	res, err = tx.Exec(`INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at, client_id, scope)
+	          VALUES (?, ?, ?, ?, ?, ?)`, current.UserID, newHash, current.FamilyID, expiresAt.UTC(), current.ClientID, current.Scope)
+	if err != nil {
+		return err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET replaced_by = ? WHERE id = ?`, newID, current.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeRefreshTokenFamily revokes every still-active token in a family
+func RevokeRefreshTokenFamily(db *sql.DB, familyID string) (int64, error) {
+	res, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = ?
+	          WHERE family_id = ? AND revoked_at IS NULL`, time.Now().UTC(), familyID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// RevokeToken adds a token id to the denylist until the token would have expired
+func RevokeToken(db *sql.DB, jti string, expiresAt time.Time) error {
+	_, err := db.Exec("INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", jti, expiresAt.UTC())
+	return err
+}
+
+// RedeemToken revokes a single-use token and reports whether this call was
+// the one that revoked it, so concurrent redemptions cannot both succeed
+func RedeemToken(db *sql.DB, jti string, expiresAt time.Time) (bool, error) {
+	res, err := db.Exec("INSERT OR IGNORE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", jti, expiresAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	return rows > 0, err
+}
+
+// IsTokenRevoked reports whether a token id is on the denylist
+func IsTokenRevoked(db *sql.DB, jti string) (bool, error) {
+	var exists int
+	err := db.QueryRow("SELECT 1 FROM revoked_tokens WHERE jti = ?", jti).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PruneRevokedTokens removes denylist entries whose tokens have expired anyway
+func PruneRevokedTokens(db *sql.DB) (int64, error) {
+	res, err := db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// StartRevokedTokenPruner periodically prunes expired denylist entries in the background
+func StartRevokedTokenPruner(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if n, err := PruneRevokedTokens(db); err != nil {
+				log.Printf("Prune revoked tokens error: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d expired revoked tokens", n)
+			}
+		}
+	}()
+}
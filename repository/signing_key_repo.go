@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"database/sql"
+	model "golang_projects/model"
+	"time"
+)
+
+// SaveRotatedSigningKey stores a new signing key and schedules retirement of the previous ones
+func SaveRotatedSigningKey(db *sql.DB, key model.SigningKey, retireAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE jwt_signing_keys SET retire_at = ? WHERE retire_at IS NULL", retireAt.UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO jwt_signing_keys (kid, alg, secret, created_at) VALUES (?, ?, ?, ?)",
+		key.Kid, key.Alg, key.Secret, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetActiveSigningKeys returns rotated keys that are not yet retired, oldest first
+func GetActiveSigningKeys(db *sql.DB) ([]model.SigningKey, error) {
+	rows, err := db.Query(`SELECT kid, alg, secret, retire_at, created_at FROM jwt_signing_keys
+	          WHERE retire_at IS NULL OR retire_at > ? ORDER BY created_at`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []model.SigningKey
+	for rows.Next() {
+		var key model.SigningKey
+		if err := rows.Scan(&key.Kid, &key.Alg, &key.Secret, &key.RetireAt, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// UpdateSigningKeySecret replaces the stored secret of a key, e.g. to encrypt a legacy plaintext secret
+func UpdateSigningKeySecret(db *sql.DB, kid string, secret []byte) error {
+	_, err := db.Exec("UPDATE jwt_signing_keys SET secret = ? WHERE kid = ?", secret, kid)
+	return err
+}
+
+// DeleteRetiredSigningKeys removes keys that no longer verify any token
+func DeleteRetiredSigningKeys(db *sql.DB) (int64, error) {
+	res, err := db.Exec("DELETE FROM jwt_signing_keys WHERE retire_at IS NOT NULL AND retire_at <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	model "golang_projects/model"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/mattn/go-sqlite3"
+)
+
+// CreateWebAuthnCredential stores a newly registered credential
+func CreateWebAuthnCredential(db *sql.DB, userID int, name string, credential webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO webauthn_credentials (user_id, credential_id, name, credential) VALUES (?, ?, ?, ?)",
+		userID, base64.RawURLEncoding.EncodeToString(credential.ID), name, string(data))
+	if err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return fmt.Errorf("credential already registered")
+		}
+		return err
+	}
+	return nil
+}
+
+// GetWebAuthnCredentialsByUser lists a user's credentials
+func GetWebAuthnCredentialsByUser(db *sql.DB, userID int) ([]model.WebAuthnCredential, error) {
+	rows, err := db.Query(`SELECT id, user_id, credential_id, name, credential, last_used_at, created_at
+	          FROM webauthn_credentials WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []model.WebAuthnCredential{}
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+// CountWebAuthnCredentials returns the number of credentials a user has registered
+func CountWebAuthnCredentials(db *sql.DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+// UpdateWebAuthnCredentialUse stores the credential state after a successful
+// assertion (sign counter, backup state) and records when it was used
+func UpdateWebAuthnCredentialUse(db *sql.DB, credential webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE webauthn_credentials SET credential = ?, last_used_at = ? WHERE credential_id = ?",
+		string(data), time.Now().UTC(), base64.RawURLEncoding.EncodeToString(credential.ID))
+	return err
+}
+
+// DeleteWebAuthnCredential removes one of a user's credentials. sql.ErrNoRows
+// is returned when the user has no such credential.
+func DeleteWebAuthnCredential(db *sql.DB, userID, id int) error {
+	res, err := db.Exec("DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanWebAuthnCredential(s scanner) (model.WebAuthnCredential, error) {
+	var (
+		credential model.WebAuthnCredential
+		data       string
+		lastUsed   sql.NullTime
+	)
+	err := s.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.Name, &data,
+		&lastUsed, &credential.CreatedAt)
+	if err != nil {
+		return credential, err
+	}
+	credential.LastUsedAt = timeOrNil(lastUsed)
+	return credential, json.Unmarshal([]byte(data), &credential.Credential)
+}
+
+// CreateWebAuthnSession stores the state of a ceremony under a hashed session token
+func CreateWebAuthnSession(db *sql.DB, session model.WebAuthnSession) error {
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return err
+	}
+	var userID interface{}
+	if session.UserID != 0 {
+		userID = session.UserID
+	}
+	_, err = db.Exec("INSERT INTO webauthn_sessions (token_hash, user_id, purpose, data, expires_at) VALUES (?, ?, ?, ?, ?)",
+		session.TokenHash, userID, session.Purpose, string(data), session.ExpiresAt.UTC())
+	return err
+}
+
+// ConsumeWebAuthnSession retrieves and deletes a ceremony session so that
+// each challenge can only be answered once. Expired sessions and sessions
+// started for another purpose yield sql.ErrNoRows.
+func ConsumeWebAuthnSession(db *sql.DB, tokenHash, purpose string) (model.WebAuthnSession, error) {
+	var (
+		session model.WebAuthnSession
+		userID  sql.NullInt64
+		data    string
+	)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return session, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT id, token_hash, user_id, purpose, data, expires_at, created_at
+	          FROM webauthn_sessions WHERE token_hash = ? AND purpose = ?`, tokenHash, purpose).
+		Scan(&session.ID, &session.TokenHash, &userID, &session.Purpose, &data, &session.ExpiresAt, &session.CreatedAt)
+	if err != nil {
+		return session, err
+	}
+	if _, err := tx.Exec("DELETE FROM webauthn_sessions WHERE id = ?", session.ID); err != nil {
+		return session, err
+	}
+	if err := tx.Commit(); err != nil {
+		return session, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return session, sql.ErrNoRows
+	}
+	session.UserID = int(userID.Int64)
+	return session, json.Unmarshal([]byte(data), &session.Data)
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"database/sql"
+	"golang_projects/middleware"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminRoutes registers routes restricted to principals holding a permission
+func AdminRoutes(r *mux.Router, db *sql.DB) {
+	r.HandleFunc("/keys/rotate", requirePermission(db, "keys:manage", HandleRotateSigningKey(db))).Methods("POST")
+	r.HandleFunc("/clients", requirePermission(db, "clients:manage", HandleCreateClient(db))).Methods("POST")
+	r.HandleFunc("/clients", requirePermission(db, "clients:manage", HandleListClients(db))).Methods("GET")
+
+	r.HandleFunc("/users/unlock", requirePermission(db, "users:write", HandleUnlockUser(db))).Methods("POST")
+
+	r.HandleFunc("/roles", requirePermission(db, "roles:manage", HandleListRoles(db))).Methods("GET")
+	r.HandleFunc("/roles", requirePermission(db, "roles:manage", HandleCreateRole(db))).Methods("POST")
+	r.HandleFunc("/permissions", requirePermission(db, "roles:manage", HandleListPermissions(db))).Methods("GET")
+	r.HandleFunc("/role_permissions", requirePermission(db, "roles:manage", HandleRolePermission(db))).Methods("POST", "DELETE")
+	r.HandleFunc("/user_roles", requirePermission(db, "roles:manage", HandleUserRoles(db))).Methods("GET", "POST", "DELETE")
+}
+
+// requirePermission authenticates the request and requires the permission
+func requirePermission(db *sql.DB, permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return middleware.JWTAuthMiddleware(middleware.RequirePermission(db, permission)(handler))
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// personalAccessScopes are the scopes private routes declare; an API key
+// created with any of them is a personal access token limited to those routes.
+// Security settings (mfa:manage) are deliberately not grantable.
+var personalAccessScopes = []string{
+	"profile:read",
+	"profile:write",
+	"account:delete",
+	"api_keys:read",
+	"api_keys:write",
+}
+
+// HandleAPIKeys lets users manage their own API keys: list (GET), create
+// (POST) and revoke (DELETE ?id=). The key itself is only returned on creation.
+func HandleAPIKeys(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "API keys belong to users", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := repository.GetAPIKeysByUser(db, principal.UserID)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch API keys", nil)
+				log.Printf("GetAPIKeysByUser error: %v", err)
+				return
+			}
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", keys)
+		case http.MethodPost:
+			createAPIKey(w, r, db, principal)
+		case http.MethodDelete:
+			revokeAPIKey(w, r, db, principal)
+		default:
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
+
+// createAPIKey issues a new API key for the caller
+func createAPIKey(w http.ResponseWriter, r *http.Request, db *sql.DB, principal middleware.Principal) {
+	// A leaked key must not be able to mint further keys
+	if principal.IsAPIKey() {
+		utils.WriteJSONResponse(w, http.StatusForbidden, false, "API keys cannot be used to create API keys", nil)
+		return
+	}
+	// Neither may a token issued to an OAuth client, whatever its scopes
+	if principal.IsScopeRestricted() {
+		utils.WriteJSONResponse(w, http.StatusForbidden, false, "API keys can only be created from a first-party login", nil)
+		return
+	}
+
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+	if req.Name == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "name is required", nil)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !hasScope(personalAccessScopes, scope) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Unsupported scope: "+scope, nil)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "expires_at must be in the future", nil)
+		return
+	}
+
+	key, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to create API key", nil)
+		log.Printf("API key generation error: %v", err)
+		return
+	}
+
+	id, err := repository.CreateAPIKey(db, model.APIKey{
+		UserID:    principal.UserID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(key),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to create API key", nil)
+		log.Printf("Create API key error: %v", err)
+		return
+	}
+
+	stored, err := repository.GetAPIKeyByID(db, principal.UserID, id)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch API key", nil)
+		log.Printf("GetAPIKeyByID error: %v", err)
+		return
+	}
+
+	response := struct {
+		model.APIKey
+		Key string `json:"key"`
+	}{
+		APIKey: stored,
+		Key:    key,
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, true, "API key created successfully", response)
+	log.Printf("User %d created API key %s", principal.UserID, prefix)
+}
+
+// revokeAPIKey revokes one of the caller's API keys
+func revokeAPIKey(w http.ResponseWriter, r *http.Request, db *sql.DB, principal middleware.Principal) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid API key ID", nil)
+		return
+	}
+
+	err = repository.RevokeAPIKey(db, principal.UserID, id)
+	if err == sql.ErrNoRows {
+		utils.WriteJSONResponse(w, http.StatusNotFound, false, "API key not found", nil)
+		return
+	}
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to revoke API key", nil)
+		log.Printf("Revoke API key error: %v", err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, true, "API key revoked successfully", nil)
+	log.Printf("User %d revoked API key %d", principal.UserID, id)
+}
@@ -3,8 +3,13 @@ package routes
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"golang_projects/mailer"
+	"golang_projects/middleware"
 	"golang_projects/model"
+	"golang_projects/password"
+	"golang_projects/policy"
 	"golang_projects/repository"
 	utils "golang_projects/utility"
 	"log"
@@ -12,13 +17,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // HandleRegister handles user registration
-func HandleRegister(db *sql.DB) http.HandlerFunc {
+func HandleRegister(db *sql.DB, m mailer.Mailer, passwords *password.Policy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
@@ -58,7 +62,7 @@ func HandleRegister(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Validate user input
-		if err := validateUser(user, true); err != nil {
+		if err := validateUser(user, true, passwords); err != nil {
 			utils.WriteJSONResponse(w, http.StatusBadRequest, false, err.Error(), nil)
 			log.Printf("Validation error: %v", err)
 			return
@@ -81,13 +85,21 @@ func HandleRegister(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Ask the user to prove they own the address
+		created, err := repository.GetUserByEmail(db, user.Email)
+		if err != nil {
+			log.Printf("GetUserByEmail error: %v", err)
+		} else if err := sendVerificationEmail(m, created, created.Email); err != nil {
+			log.Printf("Send verification email error: %v", err)
+		}
+
 		// Success response
-		utils.WriteJSONResponse(w, http.StatusCreated, true, "User registered successfully", nil)
+		utils.WriteJSONResponse(w, http.StatusCreated, true, "User registered successfully; check your email to verify your address", nil)
 	}
 }
 
 // validateUser validates the user input
-func validateUser(user model.User, isRegister bool) error {
+func validateUser(user model.User, isRegister bool, passwords *password.Policy) error {
 	if isRegister {
 		if user.Name == "" {
 			return fmt.Errorf("name is required")
@@ -104,14 +116,7 @@ func validateUser(user model.User, isRegister bool) error {
 		return fmt.Errorf("email is not valid")
 	}
 
-	if user.Password == "" {
-		return fmt.Errorf("password is required")
-	}
-	if len(user.Password) < 6 || !containsSpecialChar(user.Password) {
-		return fmt.Errorf("password must be at least 6 characters long and include at least one special character")
-	}
-
-	return nil
+	return passwords.Validate(user.Password, password.User{Email: user.Email, Name: user.Name})
 }
 
 // isValidEmail checks if the email is valid
@@ -121,16 +126,6 @@ func isValidEmail(email string) bool {
 	return matched
 }
 
-// containsSpecialChar checks if a string contains a special character
-func containsSpecialChar(s string) bool {
-	for _, char := range s {
-		if unicode.IsSymbol(char) || unicode.IsPunct(char) {
-			return true
-		}
-	}
-	return false
-}
-
 // HandleLogin handles user login
 func HandleLogin(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -150,54 +145,111 @@ func HandleLogin(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Retrieve user from DB
-		user, err := repository.GetUserLogin(db, credentials.Email)
-		if err != nil {
-			log.Printf("User not found: %v", err)
-			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid email or password", nil)
+		user, err := authenticatePassword(db, credentials.Email, credentials.Password)
+		if errors.Is(err, errAccountLocked) {
+			writeAccountLocked(w, user)
 			return
 		}
-
-		// Log the retrieved user details for debugging
-		log.Printf("Retrieved User: %+v", user)
-
-		// Check if the password matches
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(credentials.Password))
 		if err != nil {
-			log.Printf("Password mismatch: %v", err)
 			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid email or password", nil)
 			return
 		}
+		if signInRefused(w, db, user) {
+			return
+		}
 
-		// Generate JWT token
-		token, err := utils.GenerateJWT(user.ID)
+		writeFirstFactorResponse(w, db, user)
+	}
+}
+
+// writeFirstFactorResponse completes a sign-in whose first factor was
+// accepted: users with MFA get a challenge to complete at /login/mfa,
+// everyone else gets tokens
+func writeFirstFactorResponse(w http.ResponseWriter, db *sql.DB, user model.User) {
+	methods, err := mfaMethods(db, user.ID)
+	if err != nil {
+		log.Printf("GetUserMFA error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate token", nil)
+		return
+	}
+	if len(methods) > 0 {
+		challenge, err := startMFAChallenge(db, user.ID, methods)
 		if err != nil {
-			log.Printf("JWT generation error: %v", err)
-			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate token", nil)
+			log.Printf("MFA challenge error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start MFA verification", nil)
 			return
 		}
+		utils.WriteJSONResponse(w, http.StatusOK, true, "MFA verification required", challenge)
+		return
+	}
 
-		// Success response
-		response := struct {
-			ID    int    `json:"id"`
-			Name  string `json:"name"`
-			Email string `json:"email"`
-			Token string `json:"access_token"`
-		}{
-			ID:    user.ID,
-			Name:  user.Name,
-			Email: user.Email,
-			Token: token,
-		}
-
-		utils.WriteJSONResponse(w, http.StatusOK, true, "Login successful", response)
+	writeLoginResponse(w, db, user)
+}
+
+// writeLoginResponse issues a token pair for a fully authenticated user
+func writeLoginResponse(w http.ResponseWriter, db *sql.DB, user model.User) {
+	// Generate access and refresh tokens
+	tokens, err := issueTokenPair(db, user.ID)
+	if err != nil {
+		log.Printf("Token generation error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate token", nil)
+		return
+	}
+
+	// Success response
+	response := struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		TokenResponse
+	}{
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		TokenResponse: tokens,
 	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, true, "Login successful", response)
+}
+
+// errInvalidCredentials is returned for an unknown email or a wrong password
+var errInvalidCredentials = errors.New("invalid email or password")
+
+// authenticatePassword verifies an email and password pair and returns the user.
+// Wrong passwords count towards the account lockout; a locked account returns
+// errAccountLocked along with the user.
+func authenticatePassword(db *sql.DB, email, password string) (model.User, error) {
+	// Retrieve user from DB
+	user, err := repository.GetUserLogin(db, email)
+	if err != nil {
+		log.Printf("User not found: %v", err)
+		return model.User{}, errInvalidCredentials
+	}
+
+	// A locked account rejects every password, right or wrong
+	if accountLocked(user) {
+		return user, errAccountLocked
+	}
+
+	// Check if the password matches
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		log.Printf("Password mismatch: %v", err)
+		recordFailedLogin(db, user)
+		return model.User{}, errInvalidCredentials
+	}
+
+	if err := repository.ResetFailedLogins(db, user.ID); err != nil {
+		log.Printf("Reset failed logins error: %v", err)
+	}
+
+	return user, nil
 }
 
 // update User by ID
 // HandleUpdateUser handles updating user fields
 // HandleUpdateUser handles updating user fields using the repository pattern
-func HandleUpdateUser(db *sql.DB) http.HandlerFunc {
+func HandleUpdateUser(db *sql.DB, policies policy.Evaluator, m mailer.Mailer, passwords *password.Policy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPut && r.Method != http.MethodPatch {
 			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
@@ -219,77 +271,181 @@ func HandleUpdateUser(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		var updateReq model.User
-		err = json.NewDecoder(r.Body).Decode(&updateReq)
-		if err != nil {
-			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
-			log.Printf("Update decode error: %v", err)
+		// Only the account owner or a permitted caller may update the record
+		principal, _ := middleware.PrincipalFromRequest(r)
+		if !authorizeUserAction(db, policies, principal, targetUser(db, userID), "user:update") {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "You are not allowed to update this account", nil)
 			return
 		}
 
-		// Create a map for fields to update
-		updateFields := make(map[string]interface{})
+		updateUser(w, r, db, m, passwords, userID)
+	}
+}
+
+// updateUser applies the fields in the request body to the user's record
+func updateUser(w http.ResponseWriter, r *http.Request, db *sql.DB, m mailer.Mailer, passwords *password.Policy, userID int) {
+	var updateReq model.User
+	err := json.NewDecoder(r.Body).Decode(&updateReq)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		log.Printf("Update decode error: %v", err)
+		return
+	}
+
+	// The password and the email address control the account, so a personal
+	// access token or an OAuth client holding a write scope cannot change them
+	if principal, _ := middleware.PrincipalFromRequest(r); principal.IsScopeRestricted() &&
+		(updateReq.Password != "" || updateReq.Email != "") {
+		utils.WriteJSONResponse(w, http.StatusForbidden, false,
+			"The password and email address cannot be changed with a personal access token or an OAuth token", nil)
+		return
+	}
 
-		// Validate and add fields to update
-		if updateReq.Name != "" && len(updateReq.Name) < 3 {
-			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Name must be at least 3 characters long", nil)
+	// Create a map for fields to update
+	updateFields := make(map[string]interface{})
+
+	// Validate and add fields to update
+	if updateReq.Name != "" && len(updateReq.Name) < 3 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Name must be at least 3 characters long", nil)
+		return
+	}
+	if updateReq.Name != "" {
+		updateFields["name"] = updateReq.Name
+	}
+
+	// A new address is pending until it is verified; sign-in keeps using the current one
+	if updateReq.Email != "" {
+		if !isValidEmail(updateReq.Email) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "email is not valid", nil)
 			return
 		}
-		if updateReq.Name != "" {
-			updateFields["name"] = updateReq.Name
+		existing, err := repository.GetUserByEmail(db, updateReq.Email)
+		if err != nil && err != sql.ErrNoRows {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to update user", nil)
+			log.Printf("GetUserByEmail error: %v", err)
+			return
 		}
-
-		if updateReq.Email != "" {
-			updateFields["email"] = updateReq.Email
+		if err == nil && existing.ID != userID {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "Email already exists", nil)
+			return
 		}
-
-		if updateReq.Phone != "" {
-			updateFields["phone"] = updateReq.Phone
+		if err == nil {
+			// Changing back to the current address cancels a pending change
+			updateFields["pending_email"] = nil
+		} else {
+			updateFields["pending_email"] = updateReq.Email
 		}
+	}
 
-		if updateReq.Address != "" {
-			updateFields["address"] = updateReq.Address
-		}
+	// A new number must be verified again before it can be used to sign in
+	if updateReq.Phone != "" {
+		updateFields["phone"] = updateReq.Phone
+		updateFields["phone_verified_at"] = nil
+	}
 
-		// Check if password is being updated
-		if updateReq.Password != "" {
-			// Hash the new password
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(updateReq.Password), bcrypt.DefaultCost)
-			if err != nil {
-				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to hash password", nil)
-				log.Printf("Hash password error: %v", err)
-				return
-			}
-			updateFields["password"] = string(hashedPassword)
-		}
+	if updateReq.Address != "" {
+		updateFields["address"] = updateReq.Address
+	}
 
-		// If no fields to update, return an error
-		if len(updateFields) == 0 {
-			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "No fields to update", nil)
+	// Region drives authorization policies, so owners cannot change their own
+	if updateReq.Region != "" {
+		principal, _ := middleware.PrincipalFromRequest(r)
+		if !middleware.HasPermission(db, principal, "users:write") {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "Only administrators can change a user's region", nil)
 			return
 		}
+		updateFields["region"] = updateReq.Region
+	}
 
-		// Use repository to update the user
-		rowsAffected, err := repository.UpdateUserByID(db, userID, updateFields)
+	// Check if password is being updated; the replaced hash goes to the password history
+	if updateReq.Password != "" {
+		// Compare with the name and email the user will have after the update
+		current, err := repository.GetUserByID(db, userID)
+		if err == sql.ErrNoRows {
+			utils.WriteJSONResponse(w, http.StatusNotFound, false, "User not found", nil)
+			return
+		}
 		if err != nil {
 			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to update user", nil)
-			log.Printf("Update user error: %v", err)
+			log.Printf("GetUserByID error: %v", err)
+			return
+		}
+		personal := password.User{Email: current.Email, Name: current.Name}
+		if updateReq.Email != "" {
+			personal.Email = updateReq.Email
+		}
+		if updateReq.Name != "" {
+			personal.Name = updateReq.Name
+		}
+		if err := passwords.Validate(updateReq.Password, personal); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+		err = checkPasswordReuse(db, passwords, userID, updateReq.Password)
+		if errors.Is(err, errPasswordReused) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, passwordReusedMessage(passwords), nil)
+			return
+		}
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to update user", nil)
+			log.Printf("Password history error: %v", err)
 			return
 		}
 
-		if rowsAffected == 0 {
-			utils.WriteJSONResponse(w, http.StatusNotFound, false, "User not found", nil)
-			log.Printf("No user found with ID: %d", userID)
+		// Hash the new password
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(updateReq.Password), bcrypt.DefaultCost)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to hash password", nil)
+			log.Printf("Hash password error: %v", err)
 			return
 		}
+		updateFields["password"] = string(hashedPassword)
+	}
 
-		// Success response
-		utils.WriteJSONResponse(w, http.StatusOK, true, "User updated successfully", nil)
+	// If no fields to update, return an error
+	if len(updateFields) == 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "No fields to update", nil)
+		return
+	}
+
+	// Use repository to update the user; a new password and the history entry
+	// for the old one are written together
+	var rowsAffected int64
+	if _, ok := updateFields["password"]; ok {
+		rowsAffected, err = repository.UpdateUserPasswordByID(db, userID, updateFields, passwords.PreviousPasswords())
+	} else {
+		rowsAffected, err = repository.UpdateUserByID(db, userID, updateFields)
+	}
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to update user", nil)
+		log.Printf("Update user error: %v", err)
+		return
+	}
+
+	if rowsAffected == 0 {
+		utils.WriteJSONResponse(w, http.StatusNotFound, false, "User not found", nil)
+		log.Printf("No user found with ID: %d", userID)
+		return
+	}
+
+	if updateFields["pending_email"] != nil {
+		if user, err := repository.GetUserByID(db, userID); err != nil {
+			log.Printf("GetUserByID error: %v", err)
+		} else if err := sendVerificationEmail(m, user, user.PendingEmail); err != nil {
+			log.Printf("Send verification email error: %v", err)
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, true,
+			"User updated successfully; the new email address takes effect once it is verified", nil)
 		log.Printf("User with ID %d updated successfully", userID)
+		return
 	}
+
+	// Success response
+	utils.WriteJSONResponse(w, http.StatusOK, true, "User updated successfully", nil)
+	log.Printf("User with ID %d updated successfully", userID)
 }
 
-func HandleDeleteUser(db *sql.DB) http.HandlerFunc {
+func HandleDeleteUser(db *sql.DB, policies policy.Evaluator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
@@ -311,6 +467,13 @@ func HandleDeleteUser(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Only the account owner or a permitted caller may delete the record
+		principal, _ := middleware.PrincipalFromRequest(r)
+		if !authorizeUserAction(db, policies, principal, targetUser(db, userID), "user:delete") {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "You are not allowed to delete this account", nil)
+			return
+		}
+
 		// Use repository to delete the user
 		rowsAffected, err := repository.DeleteUserByID(db, userID)
 		if err != nil {
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"golang_projects/mailer"
+	"golang_projects/middleware"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestPersonalAccessTokenCannotTakeOverAccount(t *testing.T) {
+	db := newTestDB(t)
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"},
+		DisableRateLimits: true,
+	})
+	middleware.SetAPIKeyAuthenticator(func(key string) (middleware.Principal, error) {
+		return middleware.AuthenticateAPIKey(db, key)
+	})
+	t.Cleanup(func() { middleware.SetAPIKeyAuthenticator(nil) })
+	userID := createTestUser(t, db, "Alice", "alice@example.com")
+
+	token := loginTokens(t, router, "alice@example.com")
+	code, resp := doJSON(t, router, http.MethodPost, "/api/v1/mobile/api_keys", token,
+		map[string]interface{}{"name": "profile sync", "scopes": []string{"profile:write"}})
+	if code != http.StatusCreated {
+		t.Fatalf("create personal access token: %d %s", code, resp.Message)
+	}
+	var pat struct {
+		Key string `json:"key"`
+	}
+	decodeData(t, resp, &pat)
+
+	for _, path := range []string{"/api/v1/mobile/me", "/api/v1/mobile/update_user?id=" + strconv.Itoa(userID)} {
+		for _, body := range []map[string]string{
+			{"password": "Tr0ub4dor&3-horse"},
+			{"email": "mallory@example.com"},
+		} {
+			if code, resp := doJSON(t, router, http.MethodPatch, path, pat.Key, body); code != http.StatusForbidden {
+				t.Fatalf("PATCH %s %v with a personal access token: %d %s", path, body, code, resp.Message)
+			}
+		}
+	}
+
+	// The scope still covers the rest of the profile
+	if code, resp := doJSON(t, router, http.MethodPatch, "/api/v1/mobile/me", pat.Key, map[string]string{"name": "Alice Smith"}); code != http.StatusOK {
+		t.Fatalf("rename with a personal access token: %d %s", code, resp.Message)
+	}
+
+	// The account is unchanged: the old password still signs in
+	loginTokens(t, router, "alice@example.com")
+}
@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// HandleCreateClient registers an OAuth client. The client secret of a
+// confidential client is only returned in this response.
+func HandleCreateClient(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		var req struct {
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirect_uris"`
+			GrantTypes   []string `json:"grant_types"`
+			Scopes       []string `json:"scopes"`
+			Confidential bool     `json:"confidential"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+
+		if req.Name == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "name is required", nil)
+			return
+		}
+		if len(req.GrantTypes) == 0 {
+			req.GrantTypes = []string{"authorization_code", "refresh_token"}
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = supportedScopes
+		}
+		for _, grantType := range req.GrantTypes {
+			if !hasScope(supportedGrantTypes, grantType) {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Unsupported grant type: "+grantType, nil)
+				return
+			}
+		}
+		for _, scope := range req.Scopes {
+			if !hasScope(supportedScopes, scope) && !hasScope(apiScopes, scope) {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Unsupported scope: "+scope, nil)
+				return
+			}
+		}
+		for _, uri := range req.RedirectURIs {
+			parsed, err := url.Parse(uri)
+			if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "redirect_uris must be absolute URIs without a fragment", nil)
+				return
+			}
+		}
+		if hasScope(req.GrantTypes, "client_credentials") && !req.Confidential {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "client_credentials requires a confidential client", nil)
+			return
+		}
+		if hasScope(req.GrantTypes, "authorization_code") && len(req.RedirectURIs) == 0 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "redirect_uris are required for the authorization_code grant", nil)
+			return
+		}
+
+		clientID, err := utils.GenerateOpaqueToken(16)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to create client", nil)
+			log.Printf("Client id generation error: %v", err)
+			return
+		}
+
+		client := model.OAuthClient{
+			ClientID:     clientID,
+			Name:         req.Name,
+			RedirectURIs: req.RedirectURIs,
+			GrantTypes:   req.GrantTypes,
+			Scopes:       req.Scopes,
+		}
+
+		var secret string
+		if req.Confidential {
+			secret, err = utils.GenerateOpaqueToken(32)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to create client", nil)
+				log.Printf("Client secret generation error: %v", err)
+				return
+			}
+			client.ClientSecretHash = utils.HashToken(secret)
+		}
+
+		if err := repository.CreateOAuthClient(db, client); err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to create client", nil)
+			log.Printf("Create client error: %v", err)
+			return
+		}
+
+		client, err = repository.GetOAuthClient(db, clientID)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch client", nil)
+			log.Printf("GetOAuthClient error: %v", err)
+			return
+		}
+
+		response := struct {
+			model.OAuthClient
+			ClientSecret string `json:"client_secret,omitempty"`
+		}{
+			OAuthClient:  client,
+			ClientSecret: secret,
+		}
+
+		utils.WriteJSONResponse(w, http.StatusCreated, true, "Client registered successfully", response)
+	}
+}
+
+// HandleListClients lists the registered OAuth clients
+func HandleListClients(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients, err := repository.GetAllOAuthClients(db)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch clients", nil)
+			log.Printf("GetAllOAuthClients error: %v", err)
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Success", clients)
+	}
+}
@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang_projects/mailer"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// EmailVerificationTTL is how long a verification link stays valid
+var EmailVerificationTTL = utils.GetEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+
+// RequireEmailVerification blocks password sign-in until the email address is verified
+var RequireEmailVerification = utils.GetEnvBool("REQUIRE_EMAIL_VERIFICATION", true)
+
+// sendVerificationEmail mails the user a signed link to verify an address:
+// their current one, or a new one waiting to replace it. The token is bound
+// to the address, so changing it again voids older links.
+func sendVerificationEmail(m mailer.Mailer, user model.User, email string) error {
+	token, err := utils.GenerateActionToken(utils.PurposeEmailVerification, user.ID, EmailVerificationTTL,
+		map[string]interface{}{"email": email})
+	if err != nil {
+		return err
+	}
+
+	link := utils.Issuer + "/api/v1/public/verify_email?token=" + url.QueryEscape(token)
+	return m.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\n"+
+			"The link expires in %s. If you did not request this, you can ignore this email.\n",
+			user.Name, link, EmailVerificationTTL),
+	})
+}
+
+// HandleVerifyEmail redeems a verification link, either opened directly
+// (GET ?token=) or submitted by a client (POST {"token": ...})
+func HandleVerifyEmail(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if r.Method == http.MethodPost {
+			var req struct {
+				Token string `json:"token"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+				return
+			}
+			token = req.Token
+		}
+		if token == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "token is required", nil)
+			return
+		}
+
+		claims, err := utils.ValidateActionToken(token, utils.PurposeEmailVerification)
+		if errors.Is(err, utils.ErrTokenExpired) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Verification link has expired", nil)
+			return
+		}
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid or already used verification link", nil)
+			return
+		}
+
+		userID := int(claims["sub"].(float64))
+		email, _ := claims["email"].(string)
+		verified, err := repository.MarkEmailVerified(db, userID, email)
+		if errors.Is(err, repository.ErrEmailTaken) {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "Email already exists", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Mark email verified error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify email", nil)
+			return
+		}
+
+		// Single use: the link cannot be redeemed again until it would have expired anyway
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		if err := repository.RevokeToken(db, jti, time.Unix(int64(exp), 0)); err != nil {
+			log.Printf("Revoke verification token error: %v", err)
+		}
+
+		if !verified {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid or already used verification link", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Email verified successfully", nil)
+		log.Printf("User %d verified %s", userID, email)
+	}
+}
+
+// HandleResendVerification sends a new verification link. The response is the
+// same whether or not the address belongs to an unverified account.
+func HandleResendVerification(db *sql.DB, m mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "email is required", nil)
+			return
+		}
+
+		// The address is either an account's unverified address or a change waiting to be verified
+		user, err := repository.GetUserByEmail(db, req.Email)
+		if err == sql.ErrNoRows {
+			user, err = repository.GetUserByPendingEmail(db, req.Email)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Get user for verification error: %v", err)
+		}
+		if err == nil && (!user.EmailVerified || user.PendingEmail == req.Email) {
+			if err := sendVerificationEmail(m, user, req.Email); err != nil {
+				log.Printf("Send verification email error: %v", err)
+			}
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true,
+			"If the address is waiting to be verified, a verification email has been sent", nil)
+	}
+}
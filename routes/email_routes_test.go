@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"golang_projects/mailer"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sentMessage is an email written to the outbox
+type sentMessage struct {
+	To   string
+	Body string
+}
+
+// outboxMessages returns the messages in the outbox directory, oldest first
+func outboxMessages(t *testing.T, dir string) []sentMessage {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+
+	var messages []sentMessage
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, body, _ := strings.Cut(string(data), "\r\n\r\n")
+		var msg sentMessage
+		for _, line := range strings.Split(header, "\r\n") {
+			if to, ok := strings.CutPrefix(line, "To: "); ok {
+				msg.To = to
+			}
+		}
+		msg.Body = strings.ReplaceAll(body, "\r\n", "\n")
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// verificationToken extracts the token from the link in a verification email
+func verificationToken(t *testing.T, msg sentMessage) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(msg.Body, "/verify_email?token=")
+	if !ok {
+		t.Fatalf("no verification link in %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// newEmailRouter returns the application router writing email to a temporary outbox
+func newEmailRouter(t *testing.T) (http.Handler, string) {
+	t.Helper()
+	outbox := t.TempDir()
+	db := newTestDB(t)
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: outbox, From: "no-reply@localhost"},
+		DisableRateLimits: true,
+	})
+	createTestUser(t, db, "Alice", "alice@example.com")
+	createTestUser(t, db, "Bob", "bob@example.com")
+	return router, outbox
+}
+
+func TestEmailChangeIsPendingUntilVerified(t *testing.T) {
+	router, outbox := newEmailRouter(t)
+	token := loginTokens(t, router, "alice@example.com")
+
+	code, resp := doJSON(t, router, http.MethodPatch, "/api/v1/mobile/me", token, map[string]string{"email": "alice@new.example.com"})
+	if code != http.StatusOK {
+		t.Fatalf("change email: %d %s", code, resp.Message)
+	}
+
+	// the current address stays in use until the new one is verified
+	var me struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		PendingEmail  string `json:"pending_email"`
+	}
+	_, resp = doJSON(t, router, http.MethodGet, "/api/v1/mobile/me", token, nil)
+	decodeData(t, resp, &me)
+	if me.Email != "alice@example.com" || !me.EmailVerified || me.PendingEmail != "alice@new.example.com" {
+		t.Fatalf("after the change request: %+v", me)
+	}
+	loginTokens(t, router, "alice@example.com")
+
+	messages := outboxMessages(t, outbox)
+	if len(messages) != 1 || messages[0].To != "alice@new.example.com" {
+		t.Fatalf("expected one email to the new address, got %+v", messages)
+	}
+	link := "/api/v1/public/verify_email?token=" + url.QueryEscape(verificationToken(t, messages[0]))
+	if code, resp := doJSON(t, router, http.MethodGet, link, "", nil); code != http.StatusOK {
+		t.Fatalf("verify new address: %d %s", code, resp.Message)
+	}
+
+	_, resp = doJSON(t, router, http.MethodGet, "/api/v1/mobile/me", token, nil)
+	me.PendingEmail = ""
+	decodeData(t, resp, &me)
+	if me.Email != "alice@new.example.com" || !me.EmailVerified || me.PendingEmail != "" {
+		t.Fatalf("after verification: %+v", me)
+	}
+	loginTokens(t, router, "alice@new.example.com")
+	code, _ = doJSON(t, router, http.MethodPost, "/api/v1/public/login", "",
+		map[string]string{"email": "alice@example.com", "password": testPassword})
+	if code == http.StatusOK {
+		t.Fatal("the old address still signs in")
+	}
+
+	// the link is single use
+	if code, _ := doJSON(t, router, http.MethodGet, link, "", nil); code != http.StatusBadRequest {
+		t.Fatalf("reused link: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestEmailChangeToTakenAddress(t *testing.T) {
+	router, outbox := newEmailRouter(t)
+	token := loginTokens(t, router, "alice@example.com")
+
+	code, _ := doJSON(t, router, http.MethodPatch, "/api/v1/mobile/me", token, map[string]string{"email": "bob@example.com"})
+	if code != http.StatusConflict {
+		t.Fatalf("change to a taken address: got %d, want %d", code, http.StatusConflict)
+	}
+	if messages := outboxMessages(t, outbox); len(messages) != 0 {
+		t.Fatalf("unexpected email: %+v", messages)
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	router, outbox := newEmailRouter(t)
+
+	code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/register", "", map[string]string{
+		"name": "Carol", "email": "carol@example.com", "password": "Tr0ub4dor&3-horse",
+		"phone": "5550001111", "address": "1 Main Street",
+	})
+	if code != http.StatusCreated {
+		t.Fatalf("register: %d %s", code, resp.Message)
+	}
+
+	// verified and unknown addresses get the same answer and no email
+	for _, email := range []string{"carol@example.com", "alice@example.com", "nobody@example.com"} {
+		code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/verify_email/resend", "", map[string]string{"email": email})
+		if code != http.StatusOK {
+			t.Fatalf("resend to %s: %d %s", email, code, resp.Message)
+		}
+	}
+	messages := outboxMessages(t, outbox)
+	if len(messages) != 2 || messages[0].To != "carol@example.com" || messages[1].To != "carol@example.com" {
+		t.Fatalf("expected the registration email and one resend to carol, got %+v", messages)
+	}
+
+	code, resp = doJSON(t, router, http.MethodPost, "/api/v1/public/verify_email", "",
+		map[string]string{"token": verificationToken(t, messages[1])})
+	if code != http.StatusOK {
+		t.Fatalf("verify with the resent link: %d %s", code, resp.Message)
+	}
+	code, resp = doJSON(t, router, http.MethodPost, "/api/v1/public/login", "",
+		map[string]string{"email": "carol@example.com", "password": "Tr0ub4dor&3-horse"})
+	if code != http.StatusOK {
+		t.Fatalf("login after verification: %d %s", code, resp.Message)
+	}
+
+	// once verified, the older link is spent as well
+	code, _ = doJSON(t, router, http.MethodPost, "/api/v1/public/verify_email", "",
+		map[string]string{"token": verificationToken(t, messages[0])})
+	if code != http.StatusBadRequest {
+		t.Fatalf("older link after verification: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestResendVerificationForPendingEmail(t *testing.T) {
+	router, outbox := newEmailRouter(t)
+	token := loginTokens(t, router, "alice@example.com")
+
+	if code, resp := doJSON(t, router, http.MethodPatch, "/api/v1/mobile/me", token, map[string]string{"email": "alice@new.example.com"}); code != http.StatusOK {
+		t.Fatalf("change email: %d %s", code, resp.Message)
+	}
+	if code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/verify_email/resend", "", map[string]string{"email": "alice@new.example.com"}); code != http.StatusOK {
+		t.Fatalf("resend: %d %s", code, resp.Message)
+	}
+
+	messages := outboxMessages(t, outbox)
+	if len(messages) != 2 || messages[1].To != "alice@new.example.com" {
+		t.Fatalf("expected a second email to the new address, got %+v", messages)
+	}
+	code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/verify_email", "",
+		map[string]string{"token": verificationToken(t, messages[1])})
+	if code != http.StatusOK {
+		t.Fatalf("verify with the resent link: %d %s", code, resp.Message)
+	}
+	loginTokens(t, router, "alice@new.example.com")
+}
@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"database/sql"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HandleRotateSigningKey generates a new HMAC signing key. The previous key
+// keeps verifying tokens until they have all expired.
+func HandleRotateSigningKey(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		previous := utils.JWTKeys().Signing()
+		if _, ok := previous.Method.(*jwt.SigningMethodHMAC); !ok {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Only HMAC keys can be rotated at runtime", nil)
+			return
+		}
+
+		key, err := utils.NewHMACKey(previous.Method)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate key", nil)
+			log.Printf("Generate signing key error: %v", err)
+			return
+		}
+
+		sealed, err := utils.EncryptSecret(key.Private.([]byte), utils.SigningKeyPurpose(key.ID))
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate key", nil)
+			log.Printf("Encrypt signing key error: %v", err)
+			return
+		}
+
+		retireAt := time.Now().Add(utils.KeyRetention)
+		record := model.SigningKey{Kid: key.ID, Alg: key.Method.Alg(), Secret: []byte(sealed)}
+		if err := repository.SaveRotatedSigningKey(db, record, retireAt); err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to store key", nil)
+			log.Printf("Save signing key error: %v", err)
+			return
+		}
+
+		utils.JWTKeys().Promote(key, retireAt)
+		log.Printf("Rotated signing key %s -> %s", previous.ID, key.ID)
+
+		response := struct {
+			Kid              string    `json:"kid"`
+			Alg              string    `json:"alg"`
+			PreviousKid      string    `json:"previous_kid"`
+			PreviousRetireAt time.Time `json:"previous_retire_at"`
+		}{
+			Kid:              key.ID,
+			Alg:              key.Method.Alg(),
+			PreviousKid:      previous.ID,
+			PreviousRetireAt: retireAt,
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Signing key rotated successfully", response)
+	}
+}
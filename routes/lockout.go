@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// LockoutThreshold is how many consecutive wrong passwords lock an account; 0 disables lockout
+var LockoutThreshold = utils.GetEnvInt("LOCKOUT_THRESHOLD", 5)
+
+// LockoutDuration is how long a locked account stays locked before it unlocks itself
+var LockoutDuration = utils.GetEnvDuration("LOCKOUT_DURATION", 15*time.Minute)
+
+// MFALockoutThreshold is how many consecutive wrong second-factor codes lock
+// an account, across challenges and sign-in pages; 0 disables it
+var MFALockoutThreshold = utils.GetEnvInt("MFA_LOCKOUT_THRESHOLD", 5)
+
+// errAccountLocked is returned while an account is locked after repeated failed logins
+var errAccountLocked = errors.New("account is locked")
+
+// accountLocked reports whether the user's lockout is still in effect
+func accountLocked(user model.User) bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// recordFailedLogin counts a wrong password and locks the account once the threshold is reached
+func recordFailedLogin(db *sql.DB, user model.User) {
+	if LockoutThreshold <= 0 {
+		return
+	}
+	locked, err := repository.RecordFailedLogin(db, user.ID, LockoutThreshold, time.Now().Add(LockoutDuration))
+	if err != nil {
+		log.Printf("Record failed login error: %v", err)
+		return
+	}
+	if locked {
+		log.Printf("User %d locked for %s after %d failed logins", user.ID, LockoutDuration, LockoutThreshold)
+	}
+}
+
+// recordFailedSecondFactor counts a wrong TOTP, recovery code or security key
+// assertion and locks the account once the threshold is reached
+func recordFailedSecondFactor(db *sql.DB, userID int) {
+	if MFALockoutThreshold <= 0 {
+		return
+	}
+	locked, err := repository.RecordFailedSecondFactor(db, userID, MFALockoutThreshold, time.Now().Add(LockoutDuration))
+	if err != nil {
+		log.Printf("Record failed second factor error: %v", err)
+		return
+	}
+	if locked {
+		log.Printf("User %d locked for %s after %d wrong second-factor codes", userID, LockoutDuration, MFALockoutThreshold)
+	}
+}
+
+// resetFailedSecondFactors clears the wrong second-factor count after a successful verification
+func resetFailedSecondFactors(db *sql.DB, userID int) {
+	if err := repository.ResetFailedSecondFactors(db, userID); err != nil {
+		log.Printf("Reset failed second factors error: %v", err)
+	}
+}
+
+// writeAccountLocked responds to a sign-in attempt on a locked account
+func writeAccountLocked(w http.ResponseWriter, user model.User) {
+	if user.LockedUntil != nil {
+		retryAfter := int(time.Until(*user.LockedUntil).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	utils.WriteJSONResponse(w, http.StatusLocked, false,
+		"Account is temporarily locked after too many failed login attempts", map[string]interface{}{
+			"locked_until": user.LockedUntil,
+		})
+}
+
+// signInRefused responds and returns true when a user whose credentials were
+// accepted may still not sign in: the account is locked, or its email address
+// is unverified while verification is required
+func signInRefused(w http.ResponseWriter, db *sql.DB, user model.User) bool {
+	lockedUntil, err := repository.GetLockedUntil(db, user.ID)
+	if err != nil {
+		log.Printf("GetLockedUntil error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate token", nil)
+		return true
+	}
+	if locked := (model.User{LockedUntil: lockedUntil}); accountLocked(locked) {
+		writeAccountLocked(w, locked)
+		return true
+	}
+	if RequireEmailVerification && !user.EmailVerified {
+		utils.WriteJSONResponse(w, http.StatusForbidden, false, "Email address has not been verified", nil)
+		return true
+	}
+	return false
+}
+
+// HandleUnlockUser lifts a user's lockout before it expires
+func HandleUnlockUser(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserID int `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == 0 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "user_id is required", nil)
+			return
+		}
+
+		found, err := repository.UnlockUser(db, req.UserID)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to unlock user", nil)
+			log.Printf("Unlock user error: %v", err)
+			return
+		}
+		if !found {
+			utils.WriteJSONResponse(w, http.StatusNotFound, false, "User not found", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "User unlocked", nil)
+		log.Printf("User %d unlocked by an administrator", req.UserID)
+	}
+}
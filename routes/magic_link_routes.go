@@ -0,0 +1,184 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang_projects/mailer"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// MagicLinkTTL is how long an emailed sign-in link stays valid
+var MagicLinkTTL = utils.GetEnvDuration("MAGIC_LINK_TTL", 15*time.Minute)
+
+// MagicLinkURL is the address the sign-in email links to; the token is
+// appended as the `token` query parameter
+var MagicLinkURL = utils.GetEnv("MAGIC_LINK_URL", utils.Issuer+"/api/v1/public/login/magic/verify")
+
+// sendMagicLinkEmail mails the user a signed, single-use sign-in link bound to their address
+func sendMagicLinkEmail(m mailer.Mailer, user model.User) error {
+	token, err := utils.GenerateActionToken(utils.PurposeMagicLogin, user.ID, MagicLinkTTL,
+		map[string]interface{}{"email": user.Email})
+	if err != nil {
+		return err
+	}
+
+	link := MagicLinkURL + "?token=" + url.QueryEscape(token)
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen this link to sign in:\n\n%s\n\n"+
+			"The link expires in %s and can be used once. If you did not ask to sign in, you can ignore this email.\n",
+			user.Name, link, MagicLinkTTL),
+	})
+}
+
+// HandleMagicLinkRequest emails a passwordless sign-in link. The response is
+// the same whether or not the address belongs to an account.
+func HandleMagicLinkRequest(db *sql.DB, m mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "email is required", nil)
+			return
+		}
+
+		user, err := repository.GetUserByEmail(db, req.Email)
+		if err == nil {
+			if err := sendMagicLinkEmail(m, user); err != nil {
+				log.Printf("Send magic link email error: %v", err)
+			}
+		} else if err != sql.ErrNoRows {
+			log.Printf("GetUserByEmail error: %v", err)
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true,
+			"If the address belongs to an account, a sign-in link has been sent", nil)
+	}
+}
+
+// magicLinkTemplate asks the user to confirm the sign-in. Opening the link
+// must not redeem it: mail scanners and link previews fetch it first.
+var magicLinkTemplate = template.Must(template.New("magic_link").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in</title></head>
+<body>
+<h1>Sign in</h1>
+<form method="POST" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Continue signing in</button>
+</form>
+</body>
+</html>`))
+
+// renderMagicLinkConfirmation renders the page that submits the link's token
+func renderMagicLinkConfirmation(w http.ResponseWriter, action, token string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err := magicLinkTemplate.Execute(w, struct {
+		Action string
+		Token  string
+	}{
+		Action: action,
+		Token:  token,
+	})
+	if err != nil {
+		log.Printf("Render magic link page error: %v", err)
+	}
+}
+
+// HandleMagicLinkLogin signs in with an emailed link. Opening the link (GET
+// ?token=) only renders a confirmation page; the token is redeemed when it is
+// posted, by that page (form) or by a client (JSON {"token": ...}). The
+// response is like HandleLogin's.
+func HandleMagicLinkLogin(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			token := r.URL.Query().Get("token")
+			if token == "" {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "token is required", nil)
+				return
+			}
+			renderMagicLinkConfirmation(w, r.URL.Path, token)
+			return
+		}
+
+		var token string
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+			token = r.PostFormValue("token")
+		} else {
+			var req struct {
+				Token string `json:"token"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+				return
+			}
+			token = req.Token
+		}
+		if token == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "token is required", nil)
+			return
+		}
+
+		claims, err := utils.ValidateActionToken(token, utils.PurposeMagicLogin)
+		if errors.Is(err, utils.ErrTokenExpired) {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Sign-in link has expired", nil)
+			return
+		}
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or already used sign-in link", nil)
+			return
+		}
+
+		// Single use: redeem before anything else so concurrent requests cannot both sign in
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		redeemed, err := repository.RedeemToken(db, jti, time.Unix(int64(exp), 0))
+		if err != nil {
+			log.Printf("Redeem magic link token error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to sign in", nil)
+			return
+		}
+		if !redeemed {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or already used sign-in link", nil)
+			return
+		}
+
+		// The link is void once the account's address has changed
+		userID := int(claims["sub"].(float64))
+		email, _ := claims["email"].(string)
+		user, err := repository.GetUserByID(db, userID)
+		if err == sql.ErrNoRows || (err == nil && user.Email != email) {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or already used sign-in link", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("GetUserByID error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to sign in", nil)
+			return
+		}
+
+		// Following the link proves the user controls the address
+		if !user.EmailVerified {
+			if _, err := repository.MarkEmailVerified(db, user.ID, user.Email); err != nil {
+				log.Printf("Mark email verified error: %v", err)
+			}
+		}
+
+		writeFirstFactorResponse(w, db, user)
+	}
+}
@@ -0,0 +1,428 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// MFAChallengeTTL is how long the second step of a login can be completed
+var MFAChallengeTTL = utils.GetEnvDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+
+// maxMFAAttempts is the number of codes after which a challenge is void.
+// Wrong codes also count towards the per-user MFALockoutThreshold, which is
+// what limits guessing across challenges.
+const maxMFAAttempts = 5
+
+// MFAChallengeResponse is returned by login instead of tokens when the user
+// has MFA enabled; MFAToken is presented to /login/mfa with a code
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	Methods     []string `json:"methods"`
+	ExpiresIn   int      `json:"expires_in"`
+}
+
+// mfaMethods returns the second factors the user must complete one of to
+// sign in; an empty list means MFA is not enabled
+func mfaMethods(db *sql.DB, userID int) ([]string, error) {
+	var methods []string
+
+	mfa, err := repository.GetUserMFA(db, userID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil && mfa.TOTPEnabled() {
+		methods = append(methods, "totp")
+	}
+
+	count, err := repository.CountWebAuthnCredentials(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		methods = append(methods, "webauthn")
+	}
+
+	// Recovery codes stand in for a second factor but never enable MFA on their own
+	if len(methods) > 0 {
+		remaining, err := repository.CountRecoveryCodes(db, userID)
+		if err != nil {
+			return nil, err
+		}
+		if remaining > 0 {
+			methods = append(methods, "recovery_code")
+		}
+	}
+	return methods, nil
+}
+
+// startMFAChallenge creates the challenge token for the second login step
+func startMFAChallenge(db *sql.DB, userID int, methods []string) (MFAChallengeResponse, error) {
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return MFAChallengeResponse{}, err
+	}
+
+	err = repository.CreateMFAChallenge(db, model.MFAChallenge{
+		TokenHash: utils.HashToken(token),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(MFAChallengeTTL),
+	})
+	if err != nil {
+		return MFAChallengeResponse{}, err
+	}
+
+	return MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		Methods:     methods,
+		ExpiresIn:   int(MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// errInvalidMFAChallenge is returned for unknown, expired, completed or exhausted challenges
+var errInvalidMFAChallenge = errors.New("invalid or expired mfa token")
+
+// activeMFAChallenge looks up a challenge that can still be completed
+func activeMFAChallenge(db *sql.DB, token string) (model.MFAChallenge, error) {
+	challenge, err := repository.GetMFAChallengeByHash(db, utils.HashToken(token))
+	if err == sql.ErrNoRows {
+		return challenge, errInvalidMFAChallenge
+	}
+	if err != nil {
+		return challenge, err
+	}
+	if challenge.UsedAt.Valid || time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= maxMFAAttempts {
+		return challenge, errInvalidMFAChallenge
+	}
+	return challenge, nil
+}
+
+// verifyTOTPCode checks a code against the enrollment's secret and consumes
+// its time step so the same code cannot be used twice
+func verifyTOTPCode(db *sql.DB, mfa model.UserMFA, code string) (bool, error) {
+	secret, err := utils.DecryptSecret(mfa.TOTPSecret, utils.TOTPSecretPurpose(mfa.UserID))
+	if err != nil {
+		return false, err
+	}
+	step, ok := utils.ValidateTOTP(string(secret), code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return repository.UseTOTPStep(db, mfa.UserID, step)
+}
+
+// verifyUserTOTP checks a code for a user with TOTP enabled
+func verifyUserTOTP(db *sql.DB, userID int, code string) (bool, error) {
+	mfa, err := repository.GetUserMFA(db, userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !mfa.TOTPEnabled() {
+		return false, nil
+	}
+	return verifyTOTPCode(db, mfa, code)
+}
+
+// verifySecondFactorCode accepts either a current TOTP code or a recovery code
+func verifySecondFactorCode(db *sql.DB, userID int, code string) (bool, error) {
+	ok, err := verifyUserTOTP(db, userID, code)
+	if ok || err != nil {
+		return ok, err
+	}
+	return verifyRecoveryCode(db, userID, code)
+}
+
+// HandleLoginMFA completes a login that returned an MFA challenge, with a
+// TOTP code, a WebAuthn assertion started at /login/mfa/webauthn or a
+// recovery code.
+// The response is the same as a login without MFA.
+func HandleLoginMFA(db *sql.DB, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		var req struct {
+			MFAToken     string          `json:"mfa_token"`
+			Code         string          `json:"code"`
+			SessionToken string          `json:"session_token"`
+			Credential   json.RawMessage `json:"credential"`
+			RecoveryCode string          `json:"recovery_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+		if req.MFAToken == "" || (req.Code == "" && len(req.Credential) == 0 && req.RecoveryCode == "") {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "mfa_token and a code, credential or recovery_code are required", nil)
+			return
+		}
+
+		challenge, err := activeMFAChallenge(db, req.MFAToken)
+		if err == errInvalidMFAChallenge {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired MFA token", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("GetMFAChallengeByHash error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify code", nil)
+			return
+		}
+
+		// The lockout also covers the second step, so a pending challenge cannot outlive it
+		lockedUntil, err := repository.GetLockedUntil(db, challenge.UserID)
+		if err != nil {
+			log.Printf("GetLockedUntil error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify code", nil)
+			return
+		}
+		if locked := (model.User{LockedUntil: lockedUntil}); accountLocked(locked) {
+			writeAccountLocked(w, locked)
+			return
+		}
+
+		// Each code checked, right or wrong, uses one of the challenge's attempts
+		if err := repository.ClaimMFAChallengeAttempt(db, challenge.ID, maxMFAAttempts); err != nil {
+			if err != repository.ErrMFAChallengeExhausted {
+				log.Printf("Claim MFA attempt error: %v", err)
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify code", nil)
+				return
+			}
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired MFA token", nil)
+			return
+		}
+
+		var ok bool
+		switch {
+		case req.RecoveryCode != "":
+			ok, err = verifyRecoveryCode(db, challenge.UserID, req.RecoveryCode)
+		case len(req.Credential) > 0:
+			ok, err = verifyWebAuthnSecondFactor(db, wa, challenge.UserID, req.SessionToken, req.Credential)
+		default:
+			ok, err = verifyUserTOTP(db, challenge.UserID, req.Code)
+		}
+		if err != nil {
+			log.Printf("Verify second factor error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify code", nil)
+			return
+		}
+		if !ok {
+			recordFailedSecondFactor(db, challenge.UserID)
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid verification code", nil)
+			return
+		}
+		resetFailedSecondFactors(db, challenge.UserID)
+
+		if err := repository.ConsumeMFAChallenge(db, challenge.ID); err != nil {
+			if err != repository.ErrMFAChallengeUsed {
+				log.Printf("Consume MFA challenge error: %v", err)
+			}
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired MFA token", nil)
+			return
+		}
+
+		user, err := repository.GetUserByID(db, challenge.UserID)
+		if err != nil {
+			log.Printf("GetUserByID error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate token", nil)
+			return
+		}
+		writeLoginResponse(w, db, user)
+	}
+}
+
+// HandleTOTPEnroll starts TOTP enrollment: it generates a secret and returns
+// it with the otpauth URI to show as a QR code. TOTP is enforced only after
+// the first code is confirmed.
+func HandleTOTPEnroll(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		user, err := repository.GetUserByID(db, principal.UserID)
+		if err != nil {
+			log.Printf("GetUserByID error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start enrollment", nil)
+			return
+		}
+
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			log.Printf("TOTP secret generation error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start enrollment", nil)
+			return
+		}
+
+		sealed, err := utils.EncryptSecret([]byte(secret), utils.TOTPSecretPurpose(user.ID))
+		if err != nil {
+			log.Printf("Encrypt TOTP secret error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start enrollment", nil)
+			return
+		}
+
+		saved, err := repository.SavePendingTOTPSecret(db, user.ID, sealed)
+		if err != nil {
+			log.Printf("Save TOTP secret error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start enrollment", nil)
+			return
+		}
+		if !saved {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "TOTP is already enabled", nil)
+			return
+		}
+
+		response := struct {
+			Secret     string `json:"secret"`
+			OTPAuthURI string `json:"otpauth_uri"`
+		}{
+			Secret:     secret,
+			OTPAuthURI: utils.TOTPURI(user.Email, secret),
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Scan the QR code and confirm with a code", response)
+	}
+}
+
+// HandleTOTPConfirm enables TOTP once the user proves their app generates valid codes
+func HandleTOTPConfirm(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+
+		mfa, err := repository.GetUserMFA(db, principal.UserID)
+		if err == sql.ErrNoRows {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "TOTP enrollment has not been started", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("GetUserMFA error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to confirm enrollment", nil)
+			return
+		}
+		if mfa.TOTPEnabled() {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "TOTP is already enabled", nil)
+			return
+		}
+
+		ok, err = verifyTOTPCode(db, mfa, req.Code)
+		if err != nil {
+			log.Printf("Verify TOTP error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to confirm enrollment", nil)
+			return
+		}
+		if !ok {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid verification code", nil)
+			return
+		}
+
+		if err := repository.EnableTOTP(db, principal.UserID); err != nil {
+			log.Printf("Enable TOTP error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to confirm enrollment", nil)
+			return
+		}
+
+		// Recovery codes are shown once, when the first second factor is enrolled
+		codes, err := ensureRecoveryCodes(db, principal.UserID)
+		if err != nil {
+			log.Printf("Generate recovery codes error: %v", err)
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "TOTP enabled successfully", recoveryCodesResponse(codes))
+		log.Printf("User %d enabled TOTP", principal.UserID)
+	}
+}
+
+// HandleTOTPDisable turns TOTP off. The caller must re-authenticate with
+// their password and a current code.
+func HandleTOTPDisable(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		var req struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+
+		user, err := repository.GetUserByID(db, principal.UserID)
+		if err != nil {
+			log.Printf("GetUserByID error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to disable TOTP", nil)
+			return
+		}
+		if _, err := authenticatePassword(db, user.Email, req.Password); err != nil {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid password", nil)
+			return
+		}
+
+		methods, err := mfaMethods(db, user.ID)
+		if err != nil {
+			log.Printf("GetUserMFA error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to disable TOTP", nil)
+			return
+		}
+		if !hasScope(methods, "totp") {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "TOTP is not enabled", nil)
+			return
+		}
+
+		ok, err = verifyUserTOTP(db, user.ID, req.Code)
+		if err != nil {
+			log.Printf("Verify TOTP error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to disable TOTP", nil)
+			return
+		}
+		if !ok {
+			recordFailedSecondFactor(db, user.ID)
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid verification code", nil)
+			return
+		}
+		resetFailedSecondFactors(db, user.ID)
+
+		if err := repository.DisableTOTP(db, user.ID); err != nil {
+			log.Printf("Disable TOTP error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to disable TOTP", nil)
+			return
+		}
+
+		clearRecoveryCodesIfMFADisabled(db, user.ID)
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "TOTP disabled successfully", nil)
+		log.Printf("User %d disabled TOTP", user.ID)
+	}
+}
@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"golang_projects/mailer"
+	utils "golang_projects/utility"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoginMFAParallelGuesses(t *testing.T) {
+	// Leave the account lockout out of it; only the challenge limit applies
+	threshold := MFALockoutThreshold
+	MFALockoutThreshold = 0
+	t.Cleanup(func() { MFALockoutThreshold = threshold })
+
+	db := newTestDB(t)
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"},
+		DisableRateLimits: true,
+	})
+	createTestUser(t, db, "Alice", "alice@example.com")
+
+	token := loginTokens(t, router, "alice@example.com")
+	code, resp := doJSON(t, router, http.MethodPost, "/api/v1/mobile/mfa/totp/enroll", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("enroll: %d %s", code, resp.Message)
+	}
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decodeData(t, resp, &enrollment)
+	totp, err := utils.TOTPCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, resp := doJSON(t, router, http.MethodPost, "/api/v1/mobile/mfa/totp/confirm", token, map[string]string{"code": totp}); code != http.StatusOK {
+		t.Fatalf("confirm: %d %s", code, resp.Message)
+	}
+
+	code, resp = doJSON(t, router, http.MethodPost, "/api/v1/public/login", "",
+		map[string]string{"email": "alice@example.com", "password": testPassword})
+	if code != http.StatusOK {
+		t.Fatalf("login: %d %s", code, resp.Message)
+	}
+	var challenge MFAChallengeResponse
+	decodeData(t, resp, &challenge)
+
+	// Codes sent at once must not get past the challenge's limit
+	wrong := "000000"
+	if wrong == totp {
+		wrong = "999999"
+	}
+	body, _ := json.Marshal(map[string]string{"mfa_token": challenge.MFAToken, "code": wrong})
+	const guesses = 4 * maxMFAAttempts
+	messages := make(chan string, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/public/login/mfa", bytes.NewReader(body)))
+			var resp apiResponse
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			messages <- resp.Message
+		}()
+	}
+	wg.Wait()
+	close(messages)
+
+	counts := map[string]int{}
+	for message := range messages {
+		counts[message]++
+	}
+	if counts["Invalid verification code"] != maxMFAAttempts || counts["Invalid or expired MFA token"] != guesses-maxMFAAttempts {
+		t.Fatalf("responses to %d parallel wrong codes: %v, want %d codes checked and the rest refused",
+			guesses, counts, maxMFAAttempts)
+	}
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"database/sql"
+
+	"github.com/gorilla/mux"
+)
+
+// OIDCRoutes registers the OAuth2 / OpenID Connect provider endpoints
+func OIDCRoutes(r *mux.Router, db *sql.DB) {
+	r.HandleFunc("/authorize", HandleAuthorize(db)).Methods("GET", "POST")
+	r.HandleFunc("/token", HandleToken(db)).Methods("POST")
+	r.HandleFunc("/userinfo", HandleUserInfo(db)).Methods("GET", "POST")
+}
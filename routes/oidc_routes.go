@@ -0,0 +1,587 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthorizationCodeTTL is how long an authorization code can be redeemed
+const AuthorizationCodeTTL = 5 * time.Minute
+
+// supportedScopes lists the scopes this provider understands
+var supportedScopes = []string{"openid", "profile", "email", "phone", "address", "offline_access"}
+
+// supportedGrantTypes lists the grant types the token endpoint accepts
+var supportedGrantTypes = []string{"authorization_code", "refresh_token", "client_credentials"}
+
+// apiScopes are the scopes a service client can be granted to call the API
+var apiScopes = []string{"users:read", "users:write", "users:delete"}
+
+// OAuthTokenResponse is the RFC 6749 token endpoint response
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// authorizeRequest holds the parameters of an authorization request
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// CSRFToken ties the sign-in form to the browser that loaded it
+	CSRFToken string
+}
+
+// authorizeCSRFCookie holds the anti-CSRF token of the sign-in form. Another
+// site cannot read it, so it cannot post the form to sign the browser in to
+// an account of its choosing (login CSRF).
+const authorizeCSRFCookie = "authorize_csrf"
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in to {{.ClientName}}</title></head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .Error}}<p style="color:red">{{.Error}}</p>{{end}}
+<form method="POST" action="/authorize">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="csrf_token" value="{{.Request.CSRFToken}}">
+<label>Email <input type="email" name="email" required></label>
+<label>Password <input type="password" name="password" required></label>
+<label>One-time or recovery code (if enabled) <input type="text" name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>`))
+
+// HandleAuthorize implements the authorization endpoint. GET renders a sign-in
+// form; POST checks the credentials and redirects back with an authorization code.
+// PKCE with S256 is mandatory for every client.
+func HandleAuthorize(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			renderAuthorizeError(w, "Invalid request")
+			return
+		}
+
+		req := authorizeRequest{
+			ResponseType:        r.Form.Get("response_type"),
+			ClientID:            r.Form.Get("client_id"),
+			RedirectURI:         r.Form.Get("redirect_uri"),
+			Scope:               r.Form.Get("scope"),
+			State:               r.Form.Get("state"),
+			Nonce:               r.Form.Get("nonce"),
+			CodeChallenge:       r.Form.Get("code_challenge"),
+			CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		}
+
+		// Errors about the client or redirect URI must not redirect
+		client, err := repository.GetOAuthClient(db, req.ClientID)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Get OAuth client error: %v", err)
+			}
+			renderAuthorizeError(w, "Unknown client")
+			return
+		}
+		if !client.HasRedirectURI(req.RedirectURI) {
+			renderAuthorizeError(w, "Invalid redirect_uri")
+			return
+		}
+
+		if !client.AllowsGrant("authorization_code") {
+			redirectWithError(w, r, req, "unauthorized_client", "Client may not use the authorization code grant")
+			return
+		}
+		if req.ResponseType != "code" {
+			redirectWithError(w, r, req, "unsupported_response_type", "Only response_type=code is supported")
+			return
+		}
+		scopes := strings.Fields(req.Scope)
+		if !client.AllowsScopes(scopes) {
+			redirectWithError(w, r, req, "invalid_scope", "Requested scope is not allowed for this client")
+			return
+		}
+		// With HMAC signing keys ID tokens are signed with the client secret
+		if hasScope(scopes, "openid") && !client.IsConfidential() && utils.IDTokensUseClientSecret() {
+			redirectWithError(w, r, req, "invalid_scope", "The openid scope requires a confidential client unless the provider signs with an asymmetric key")
+			return
+		}
+		if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+			redirectWithError(w, r, req, "invalid_request", "PKCE code_challenge with method S256 is required")
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			if req.CSRFToken, err = setAuthorizeCSRFCookie(w); err != nil {
+				log.Printf("CSRF token generation error: %v", err)
+				redirectWithError(w, r, req, "server_error", "Failed to render sign-in form")
+				return
+			}
+			renderAuthorizeForm(w, http.StatusOK, client, req, "")
+			return
+		}
+
+		cookie, err := r.Cookie(authorizeCSRFCookie)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) != 1 {
+			if req.CSRFToken, err = setAuthorizeCSRFCookie(w); err != nil {
+				log.Printf("CSRF token generation error: %v", err)
+				redirectWithError(w, r, req, "server_error", "Failed to render sign-in form")
+				return
+			}
+			renderAuthorizeForm(w, http.StatusForbidden, client, req, "Your sign-in form expired, please try again")
+			return
+		}
+		req.CSRFToken = cookie.Value
+
+		user, err := authenticatePassword(db, r.Form.Get("email"), r.Form.Get("password"))
+		if errors.Is(err, errAccountLocked) {
+			renderAuthorizeForm(w, http.StatusLocked, client, req, "Account is temporarily locked after too many failed login attempts")
+			return
+		}
+		if err != nil {
+			renderAuthorizeForm(w, http.StatusUnauthorized, client, req, "Invalid email or password")
+			return
+		}
+		if RequireEmailVerification && !user.EmailVerified {
+			renderAuthorizeForm(w, http.StatusForbidden, client, req, "Email address has not been verified")
+			return
+		}
+
+		// The sign-in form must not bypass a second factor
+		methods, err := mfaMethods(db, user.ID)
+		if err != nil {
+			log.Printf("GetUserMFA error: %v", err)
+			redirectWithError(w, r, req, "server_error", "Failed to verify credentials")
+			return
+		}
+		if len(methods) > 0 {
+			ok, err := verifySecondFactorCode(db, user.ID, r.Form.Get("otp"))
+			if err != nil {
+				log.Printf("Verify second factor error: %v", err)
+				redirectWithError(w, r, req, "server_error", "Failed to verify credentials")
+				return
+			}
+			if !ok {
+				recordFailedSecondFactor(db, user.ID)
+				message := "Invalid or missing one-time code"
+				if !hasScope(methods, "totp") {
+					message = "This account requires a security key, which this sign-in page does not support; use a recovery code instead"
+				}
+				renderAuthorizeForm(w, http.StatusUnauthorized, client, req, message)
+				return
+			}
+			resetFailedSecondFactors(db, user.ID)
+		}
+
+		code, err := utils.GenerateOpaqueToken(32)
+		if err != nil {
+			log.Printf("Authorization code generation error: %v", err)
+			redirectWithError(w, r, req, "server_error", "Failed to issue authorization code")
+			return
+		}
+
+		now := time.Now()
+		err = repository.CreateAuthorizationCode(db, model.AuthorizationCode{
+			CodeHash:            utils.HashToken(code),
+			ClientID:            client.ClientID,
+			UserID:              user.ID,
+			RedirectURI:         req.RedirectURI,
+			Scope:               strings.Join(scopes, " "),
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			AuthTime:            now,
+			ExpiresAt:           now.Add(AuthorizationCodeTTL),
+		})
+		if err != nil {
+			log.Printf("Store authorization code error: %v", err)
+			redirectWithError(w, r, req, "server_error", "Failed to issue authorization code")
+			return
+		}
+
+		params := url.Values{"code": {code}}
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		redirectWithParams(w, r, req.RedirectURI, params)
+	}
+}
+
+// setAuthorizeCSRFCookie stores a new anti-CSRF token in a cookie and returns
+// it for the sign-in form to echo back
+func setAuthorizeCSRFCookie(w http.ResponseWriter) (string, error) {
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     authorizeCSRFCookie,
+		Value:    token,
+		Path:     "/authorize",
+		MaxAge:   int(time.Hour.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(utils.Issuer, "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// renderAuthorizeForm renders the sign-in form for an authorization request
+func renderAuthorizeForm(w http.ResponseWriter, statusCode int, client model.OAuthClient, req authorizeRequest, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(statusCode)
+	err := authorizeTemplate.Execute(w, struct {
+		ClientName string
+		Request    authorizeRequest
+		Error      string
+	}{client.Name, req, message})
+	if err != nil {
+		log.Printf("Render authorize form error: %v", err)
+	}
+}
+
+// renderAuthorizeError shows an error to the user when it is unsafe to redirect
+func renderAuthorizeError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(message))
+}
+
+// redirectWithError sends an OAuth error back to the client's redirect URI
+func redirectWithError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	redirectWithParams(w, r, req.RedirectURI, params)
+}
+
+// redirectWithParams redirects to uri with params merged into its query string
+func redirectWithParams(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		renderAuthorizeError(w, "Invalid redirect_uri")
+		return
+	}
+	query := target.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// HandleToken implements the OAuth2 token endpoint
+func HandleToken(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "Method not allowed")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "Invalid form body")
+			return
+		}
+
+		client, clientSecret, err := authenticateClient(db, r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+			return
+		}
+
+		grantType := r.PostForm.Get("grant_type")
+		if !client.AllowsGrant(grantType) {
+			writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "Client may not use this grant type")
+			return
+		}
+
+		switch grantType {
+		case "authorization_code":
+			tokenFromAuthorizationCode(w, r, db, client, clientSecret)
+		case "refresh_token":
+			tokenFromRefreshToken(w, r, db, client)
+		case "client_credentials":
+			tokenFromClientCredentials(w, r, client)
+		default:
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+		}
+	}
+}
+
+// authenticateClient identifies the client from HTTP Basic credentials or the
+// form body and returns the verified client secret. Public clients only
+// present their client_id.
+func authenticateClient(db *sql.DB, r *http.Request) (model.OAuthClient, string, error) {
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err != nil {
+			return model.OAuthClient{}, "", err
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return model.OAuthClient{}, "", err
+		}
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := repository.GetOAuthClient(db, clientID)
+	if err != nil {
+		return model.OAuthClient{}, "", err
+	}
+
+	if !client.IsConfidential() {
+		return client, "", nil
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(client.ClientSecretHash)) != 1 {
+		return model.OAuthClient{}, "", errors.New("invalid client secret")
+	}
+	return client, secret, nil
+}
+
+// tokenFromAuthorizationCode redeems an authorization code (with PKCE verifier)
+func tokenFromAuthorizationCode(w http.ResponseWriter, r *http.Request, db *sql.DB, client model.OAuthClient, clientSecret string) {
+	code, err := repository.ConsumeAuthorizationCode(db, utils.HashToken(r.PostForm.Get("code")))
+	if err != nil {
+		if err != sql.ErrNoRows && !errors.Is(err, repository.ErrAuthorizationCodeUsed) {
+			log.Printf("Consume authorization code error: %v", err)
+		}
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != r.PostForm.Get("redirect_uri") || time.Now().After(code.ExpiresAt) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if !utils.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge, code.CodeChallengeMethod) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid code_verifier")
+		return
+	}
+
+	issueOAuthTokens(w, db, client, clientSecret, code.UserID, code.Scope, code.Nonce, code.AuthTime)
+}
+
+// tokenFromRefreshToken rotates a refresh token previously issued to the client
+func tokenFromRefreshToken(w http.ResponseWriter, r *http.Request, db *sql.DB, client model.OAuthClient) {
+	current, refreshToken, err := rotateRefreshToken(db, r.PostForm.Get("refresh_token"), client.ClientID)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		log.Printf("Refresh token rotation error: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to refresh token")
+		return
+	}
+
+	accessToken, err := utils.GenerateOAuthJWT(current.UserID, client.ClientID, current.Scope.String)
+	if err != nil {
+		log.Printf("JWT generation error: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        current.Scope.String,
+	})
+}
+
+// tokenFromClientCredentials issues a service token to a confidential client
+// acting on its own behalf. No refresh token is issued for this grant.
+func tokenFromClientCredentials(w http.ResponseWriter, r *http.Request, client model.OAuthClient) {
+	if !client.IsConfidential() {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "Public clients may not use client_credentials")
+		return
+	}
+
+	// Default to every API scope registered for the client
+	var scopes []string
+	if requested := r.PostForm.Get("scope"); requested != "" {
+		scopes = strings.Fields(requested)
+	} else {
+		for _, scope := range client.Scopes {
+			if hasScope(apiScopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	for _, scope := range scopes {
+		if !hasScope(apiScopes, scope) || !hasScope(client.Scopes, scope) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "Requested scope is not allowed for this client")
+			return
+		}
+	}
+
+	scope := strings.Join(scopes, " ")
+	accessToken, err := utils.GenerateServiceJWT(client.ClientID, scope)
+	if err != nil {
+		log.Printf("JWT generation error: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(utils.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	})
+}
+
+// issueOAuthTokens issues the access token, plus an ID token for the openid
+// scope and a refresh token for offline_access
+func issueOAuthTokens(w http.ResponseWriter, db *sql.DB, client model.OAuthClient, clientSecret string, userID int, scope, nonce string, authTime time.Time) {
+	scopes := strings.Fields(scope)
+	response := OAuthTokenResponse{
+		TokenType: "Bearer",
+		ExpiresIn: int(utils.AccessTokenTTL.Seconds()),
+		Scope:     scope,
+	}
+
+	var err error
+	response.AccessToken, err = utils.GenerateOAuthJWT(userID, client.ClientID, scope)
+	if err != nil {
+		log.Printf("JWT generation error: %v", err)
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+		return
+	}
+
+	if hasScope(scopes, "openid") {
+		user, err := repository.GetUserByID(db, userID)
+		if err != nil {
+			log.Printf("Get user for ID token error: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+			return
+		}
+		response.IDToken, err = utils.GenerateIDToken(userID, client.ClientID, clientSecret, nonce, authTime, userClaims(user, scopes))
+		if err != nil {
+			log.Printf("ID token generation error: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+			return
+		}
+	}
+
+	if hasScope(scopes, "offline_access") && client.AllowsGrant("refresh_token") {
+		response.RefreshToken, err = createRefreshToken(db, userID, client.ClientID, scope)
+		if err != nil {
+			log.Printf("Refresh token generation error: %v", err)
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+			return
+		}
+	}
+
+	writeOAuthJSON(w, http.StatusOK, response)
+}
+
+// HandleUserInfo returns the claims about the user the access token was issued for
+func HandleUserInfo(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		claims, err := utils.ValidateJWT(token)
+		if err != nil || claims["sub_type"] == utils.SubjectTypeClient {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+			return
+		}
+
+		userID, _ := strconv.Atoi(claims["sub"].(string))
+		user, err := repository.GetUserByID(db, userID)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("Get user for userinfo error: %v", err)
+			}
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "Unknown user")
+			return
+		}
+
+		// First-party login tokens carry no scope and may read the full profile
+		scopes := supportedScopes
+		if scope, ok := claims["scope"].(string); ok {
+			scopes = strings.Fields(scope)
+		}
+
+		info := userClaims(user, scopes)
+		info["sub"] = strconv.Itoa(user.ID)
+		writeOAuthJSON(w, http.StatusOK, info)
+	}
+}
+
+// userClaims returns the standard claims released for the granted scopes
+func userClaims(user model.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{}
+	if hasScope(scopes, "profile") {
+		claims["name"] = user.Name
+	}
+	if hasScope(scopes, "email") {
+		claims["email"] = user.Email
+	}
+	if hasScope(scopes, "phone") && user.Phone != "" {
+		claims["phone_number"] = user.Phone
+	}
+	if hasScope(scopes, "address") && user.Address != "" {
+		claims["address"] = map[string]string{"formatted": user.Address}
+	}
+	return claims
+}
+
+// hasScope reports whether scope is in scopes
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOAuthJSON writes a token endpoint response, which must never be cached
+func writeOAuthJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	writeRawJSON(w, statusCode, body)
+}
+
+// writeOAuthError writes an RFC 6749 error response
+func writeOAuthError(w http.ResponseWriter, statusCode int, code, description string) {
+	writeOAuthJSON(w, statusCode, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
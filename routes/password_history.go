@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"golang_projects/password"
+	"golang_projects/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errPasswordReused is returned for a new password matching a recent one
+var errPasswordReused = errors.New("password was used recently")
+
+// checkPasswordReuse rejects a new password that matches the user's current
+// password or one of the previous ones the policy remembers
+func checkPasswordReuse(db *sql.DB, passwords *password.Policy, userID int, newPassword string) error {
+	if passwords.History <= 0 {
+		return nil
+	}
+	hashes, err := repository.GetPasswordHashes(db, userID, passwords.PreviousPasswords())
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(newPassword)) == nil {
+			return errPasswordReused
+		}
+	}
+	return nil
+}
+
+// passwordReusedMessage explains why a recently used password was rejected
+func passwordReusedMessage(passwords *password.Policy) string {
+	if passwords.History == 1 {
+		return "password must differ from your current password"
+	}
+	return fmt.Sprintf("password must not match any of your last %d passwords", passwords.History)
+}
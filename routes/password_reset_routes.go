@@ -0,0 +1,151 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang_projects/mailer"
+	"golang_projects/model"
+	"golang_projects/password"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetTTL is how long an emailed password reset token stays valid
+var PasswordResetTTL = utils.GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+
+// PasswordResetURL is the page the reset email links to; the token is appended
+// as the `token` query parameter and the page submits it to /password/reset
+var PasswordResetURL = utils.GetEnv("PASSWORD_RESET_URL", utils.Issuer+"/reset_password")
+
+// sendPasswordResetEmail creates a reset token for the user and mails it
+func sendPasswordResetEmail(db *sql.DB, m mailer.Mailer, user model.User) error {
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		return err
+	}
+	if err := repository.CreatePasswordResetToken(db, user.ID, utils.HashToken(token), time.Now().Add(PasswordResetTTL)); err != nil {
+		return err
+	}
+
+	link := PasswordResetURL + "?token=" + url.QueryEscape(token)
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. "+
+			"To choose a new password, open this link:\n\n%s\n\n"+
+			"The link expires in %s and can be used once. If you did not ask for this, you can ignore this email; "+
+			"your password has not been changed.\n",
+			user.Name, link, PasswordResetTTL),
+	})
+}
+
+// HandleForgotPassword emails a password reset link. The response is the same
+// whether or not the address belongs to an account, and so is the time it
+// takes: the token is created and mailed in the background.
+func HandleForgotPassword(db *sql.DB, m mailer.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "email is required", nil)
+			return
+		}
+
+		user, err := repository.GetUserByEmail(db, req.Email)
+		if err == nil {
+			go func() {
+				if err := sendPasswordResetEmail(db, m, user); err != nil {
+					log.Printf("Send password reset email error: %v", err)
+				}
+			}()
+		} else if err != sql.ErrNoRows {
+			log.Printf("GetUserByEmail error: %v", err)
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true,
+			"If the address belongs to an account, a password reset email has been sent", nil)
+	}
+}
+
+// passwordResetUser returns the user an active reset token belongs to
+func passwordResetUser(db *sql.DB, tokenHash string) (model.User, error) {
+	userID, err := repository.GetPasswordResetUserID(db, tokenHash)
+	if err != nil {
+		return model.User{}, err
+	}
+	user, err := repository.GetUserByID(db, userID)
+	if err == sql.ErrNoRows {
+		return model.User{}, repository.ErrPasswordResetTokenInvalid
+	}
+	return user, err
+}
+
+// HandleResetPassword sets a new password using an emailed reset token, signs
+// the user out of every existing session and revokes their API keys
+func HandleResetPassword(db *sql.DB, passwords *password.Policy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "token and password are required", nil)
+			return
+		}
+
+		// The new password is checked against the account the token belongs to
+		tokenHash := utils.HashToken(req.Token)
+		user, err := passwordResetUser(db, tokenHash)
+		if errors.Is(err, repository.ErrPasswordResetTokenInvalid) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid or expired password reset token", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Password reset lookup error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to reset password", nil)
+			return
+		}
+		if err := passwords.Validate(req.Password, password.User{Email: user.Email, Name: user.Name}); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+		if err := checkPasswordReuse(db, passwords, user.ID, req.Password); errors.Is(err, errPasswordReused) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, passwordReusedMessage(passwords), nil)
+			return
+		} else if err != nil {
+			log.Printf("Password history error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to reset password", nil)
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Hash password error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to hash password", nil)
+			return
+		}
+
+		userID, err := repository.ResetPassword(db, tokenHash, string(hashedPassword), passwords.PreviousPasswords())
+		if errors.Is(err, repository.ErrPasswordResetTokenInvalid) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid or expired password reset token", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Reset password error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to reset password", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Password has been reset; please sign in again", nil)
+		log.Printf("Password reset for user %d", userID)
+	}
+}
@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"golang_projects/mailer"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginRightAfterPasswordReset(t *testing.T) {
+	outbox := t.TempDir()
+	db := newTestDB(t)
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: outbox, From: "no-reply@localhost"},
+		DisableRateLimits: true,
+	})
+	utils.SetSessionRevocationChecker(func(userID int, issuedAt time.Time) (bool, error) {
+		revokedAt, err := repository.SessionsRevokedAt(db, userID)
+		return issuedAt.Before(revokedAt), err
+	})
+	t.Cleanup(func() { utils.SetSessionRevocationChecker(nil) })
+	createTestUser(t, db, "Alice", "alice@example.com")
+
+	oldToken := loginTokens(t, router, "alice@example.com")
+	// Reset in a later second than the old token was issued in
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	if code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/password/forgot", "", map[string]string{"email": "alice@example.com"}); code != http.StatusOK {
+		t.Fatalf("forgot password: %d %s", code, resp.Message)
+	}
+	// The email is sent in the background, so wait for the whole link to be written
+	var resetToken string
+	for deadline := time.Now().Add(5 * time.Second); resetToken == "" && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, msg := range outboxMessages(t, outbox) {
+			if _, rest, ok := strings.Cut(msg.Body, "?token="); ok && strings.Contains(rest, "\n") {
+				token, err := url.QueryUnescape(strings.Fields(rest)[0])
+				if err != nil {
+					t.Fatal(err)
+				}
+				resetToken = token
+			}
+		}
+	}
+	if resetToken == "" {
+		t.Fatal("no password reset email was sent")
+	}
+
+	const newPassword = "Tr0ub4dor&3-horse"
+	if code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/password/reset", "",
+		map[string]string{"token": resetToken, "password": newPassword}); code != http.StatusOK {
+		t.Fatalf("reset password: %d %s", code, resp.Message)
+	}
+
+	// Signing in within the same second as the reset works
+	code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/login", "",
+		map[string]string{"email": "alice@example.com", "password": newPassword})
+	if code != http.StatusOK {
+		t.Fatalf("login after reset: %d %s", code, resp.Message)
+	}
+	var tokens TokenResponse
+	decodeData(t, resp, &tokens)
+	if code, resp := doJSON(t, router, http.MethodGet, "/api/v1/mobile/me", tokens.AccessToken, nil); code != http.StatusOK {
+		t.Fatalf("access token issued right after the reset: %d %s", code, resp.Message)
+	}
+
+	// Tokens from before the reset are signed out
+	if code, _ := doJSON(t, router, http.MethodGet, "/api/v1/mobile/me", oldToken, nil); code != http.StatusUnauthorized {
+		t.Fatalf("access token issued before the reset: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
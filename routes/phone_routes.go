@@ -0,0 +1,270 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/repository"
+	"golang_projects/sms"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PhoneOTPTTL is how long a code sent by SMS stays valid
+var PhoneOTPTTL = utils.GetEnvDuration("PHONE_OTP_TTL", 5*time.Minute)
+
+// PhoneOTPDigits is the length of codes sent by SMS
+const PhoneOTPDigits = 6
+
+// maxPhoneOTPAttempts is how many wrong codes a single SMS code tolerates
+const maxPhoneOTPAttempts = 5
+
+// PhoneOTPFailureBudget is how many wrong codes a user may enter within
+// PhoneOTPFailureWindow, however many new codes they request
+var PhoneOTPFailureBudget = utils.GetEnvInt("PHONE_OTP_FAILURE_BUDGET", 10)
+
+// PhoneOTPFailureWindow is the period over which PhoneOTPFailureBudget applies
+var PhoneOTPFailureWindow = utils.GetEnvDuration("PHONE_OTP_FAILURE_WINDOW", time.Hour)
+
+// Errors returned when a phone OTP is rejected
+var (
+	errInvalidPhoneOTP        = errors.New("invalid or expired code")
+	errPhoneOTPExhausted      = errors.New("too many wrong codes")
+	errPhoneOTPBudgetExceeded = errors.New("too many wrong codes across codes")
+)
+
+// sendPhoneOTP texts a new one-time code for the purpose to phone
+func sendPhoneOTP(db *sql.DB, sender sms.Sender, userID int, phone, purpose string) error {
+	code, err := utils.GenerateNumericCode(PhoneOTPDigits)
+	if err != nil {
+		return err
+	}
+
+	err = repository.CreatePhoneOTP(db, model.PhoneOTP{
+		UserID:    userID,
+		Phone:     phone,
+		Purpose:   purpose,
+		CodeHash:  utils.HashToken(code),
+		ExpiresAt: time.Now().Add(PhoneOTPTTL),
+	}, PhoneOTPFailureWindow)
+	if err != nil {
+		return err
+	}
+
+	return sender.Send(phone, fmt.Sprintf("Your %s code is %s. It expires in %s.", utils.TOTPIssuer, code, PhoneOTPTTL))
+}
+
+// redeemPhoneOTP checks a code against the user's latest code for the purpose
+// and consumes it. Every wrong code counts against the code's attempt limit
+// and against the user's budget, which requesting a new code does not reset.
+func redeemPhoneOTP(db *sql.DB, userID int, purpose, code string) (model.PhoneOTP, error) {
+	otp, err := repository.GetLatestPhoneOTP(db, userID, purpose)
+	if err == sql.ErrNoRows {
+		return otp, errInvalidPhoneOTP
+	}
+	if err != nil {
+		return otp, err
+	}
+	if time.Now().After(otp.ExpiresAt) {
+		return otp, errInvalidPhoneOTP
+	}
+
+	err = repository.ClaimPhoneOTPAttempt(db, otp, maxPhoneOTPAttempts, PhoneOTPFailureBudget, PhoneOTPFailureWindow)
+	switch {
+	case errors.Is(err, repository.ErrPhoneOTPBudgetExceeded):
+		return otp, errPhoneOTPBudgetExceeded
+	case errors.Is(err, repository.ErrPhoneOTPExhausted):
+		return otp, errPhoneOTPExhausted
+	case errors.Is(err, repository.ErrPhoneOTPUsed):
+		return otp, errInvalidPhoneOTP
+	case err != nil:
+		return otp, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(code)), []byte(otp.CodeHash)) != 1 {
+		return otp, errInvalidPhoneOTP
+	}
+
+	if err := repository.ConsumePhoneOTP(db, otp.ID); err != nil {
+		if errors.Is(err, repository.ErrPhoneOTPUsed) {
+			return otp, errInvalidPhoneOTP
+		}
+		return otp, err
+	}
+	return otp, nil
+}
+
+// writePhoneOTPError responds to a rejected code
+func writePhoneOTPError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPhoneOTPBudgetExceeded):
+		w.Header().Set("Retry-After", strconv.Itoa(int(PhoneOTPFailureWindow.Seconds())))
+		utils.WriteJSONResponse(w, http.StatusTooManyRequests, false, "Too many wrong codes; try again later", nil)
+	case errors.Is(err, errPhoneOTPExhausted):
+		utils.WriteJSONResponse(w, http.StatusTooManyRequests, false, "Too many wrong codes; request a new code", nil)
+	case errors.Is(err, errInvalidPhoneOTP):
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired code", nil)
+	default:
+		log.Printf("Phone OTP error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify code", nil)
+	}
+}
+
+// HandlePhoneVerificationSend texts a code to the caller's phone number
+func HandlePhoneVerificationSend(db *sql.DB, sender sms.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "Phone verification is only available to users", nil)
+			return
+		}
+
+		user, err := repository.GetUserByID(db, principal.UserID)
+		if err != nil {
+			log.Printf("GetUserByID error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to send code", nil)
+			return
+		}
+		phone := utils.NormalizePhone(user.Phone)
+		if phone == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Add a phone number to your profile first", nil)
+			return
+		}
+		if user.PhoneVerified {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "Phone number is already verified", nil)
+			return
+		}
+
+		if err := sendPhoneOTP(db, sender, user.ID, phone, model.PhoneOTPPurposeVerify); err != nil {
+			log.Printf("Send phone OTP error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to send code", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Verification code sent", map[string]int{
+			"expires_in": int(PhoneOTPTTL.Seconds()),
+		})
+	}
+}
+
+// HandlePhoneVerify confirms the caller's phone number with the code texted to it
+func HandlePhoneVerify(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "Phone verification is only available to users", nil)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "code is required", nil)
+			return
+		}
+
+		otp, err := redeemPhoneOTP(db, principal.UserID, model.PhoneOTPPurposeVerify, req.Code)
+		if err != nil {
+			writePhoneOTPError(w, err)
+			return
+		}
+
+		// The code only proves ownership of the number it was sent to
+		user, err := repository.GetUserByID(db, principal.UserID)
+		if err != nil {
+			log.Printf("GetUserByID error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify phone number", nil)
+			return
+		}
+		if utils.NormalizePhone(user.Phone) != otp.Phone {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "Phone number changed after the code was sent; request a new code", nil)
+			return
+		}
+
+		if err := repository.MarkPhoneVerified(db, user.ID, otp.Phone); err != nil {
+			if errors.Is(err, repository.ErrPhoneInUse) {
+				utils.WriteJSONResponse(w, http.StatusConflict, false, "Phone number is already verified on another account", nil)
+				return
+			}
+			log.Printf("Mark phone verified error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify phone number", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Phone number verified", nil)
+		log.Printf("User %d verified their phone number", user.ID)
+	}
+}
+
+// HandlePhoneLoginRequest texts a sign-in code to a verified phone number. The
+// response is the same whether or not the number belongs to an account.
+func HandlePhoneLoginRequest(db *sql.DB, sender sms.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Phone string `json:"phone"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || utils.NormalizePhone(req.Phone) == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "phone is required", nil)
+			return
+		}
+		phone := utils.NormalizePhone(req.Phone)
+
+		user, err := repository.GetUserByVerifiedPhone(db, phone)
+		if err == nil {
+			if err := sendPhoneOTP(db, sender, user.ID, phone, model.PhoneOTPPurposeLogin); err != nil {
+				log.Printf("Send phone OTP error: %v", err)
+			}
+		} else if err != sql.ErrNoRows {
+			log.Printf("GetUserByVerifiedPhone error: %v", err)
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true,
+			"If the number belongs to an account, a sign-in code has been sent", map[string]int{
+				"expires_in": int(PhoneOTPTTL.Seconds()),
+			})
+	}
+}
+
+// HandlePhoneLogin signs in with a verified phone number and the code texted
+// to it, and responds like HandleLogin
+func HandlePhoneLogin(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Phone string `json:"phone"`
+			Code  string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Phone == "" || req.Code == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "phone and code are required", nil)
+			return
+		}
+
+		user, err := repository.GetUserByVerifiedPhone(db, utils.NormalizePhone(req.Phone))
+		if err == sql.ErrNoRows {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired code", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("GetUserByVerifiedPhone error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify code", nil)
+			return
+		}
+
+		if _, err := redeemPhoneOTP(db, user.ID, model.PhoneOTPPurposeLogin, req.Code); err != nil {
+			writePhoneOTPError(w, err)
+			return
+		}
+		if signInRefused(w, db, user) {
+			return
+		}
+
+		writeFirstFactorResponse(w, db, user)
+	}
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang_projects/mailer"
+	"golang_projects/repository"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// recordingSender keeps the texts it is asked to send
+type recordingSender struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (s *recordingSender) Send(to, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, body)
+	return nil
+}
+
+var smsCodePattern = regexp.MustCompile(`code is (\d+)`)
+
+// lastCode returns the code in the latest text
+func (s *recordingSender) lastCode(t *testing.T) string {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		t.Fatal("no text was sent")
+	}
+	match := smsCodePattern.FindStringSubmatch(s.messages[len(s.messages)-1])
+	if match == nil {
+		t.Fatalf("no code in %q", s.messages[len(s.messages)-1])
+	}
+	return match[1]
+}
+
+func TestPhoneLoginParallelGuesses(t *testing.T) {
+	db := newTestDB(t)
+	sender := &recordingSender{}
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"},
+		SMS:               sender,
+		DisableRateLimits: true,
+	})
+	userID := createTestUser(t, db, "Alice", "alice@example.com")
+	if err := repository.MarkPhoneVerified(db, userID, "+15550100"); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/login/phone", "", map[string]string{"phone": "+15550100"}); code != http.StatusOK {
+		t.Fatalf("request code: %d %s", code, resp.Message)
+	}
+	code := sender.lastCode(t)
+
+	// Guesses sent at once must not get past the limit of wrong codes
+	const guesses = 4 * maxPhoneOTPAttempts
+	statuses := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		guess := fmt.Sprintf("%06d", i)
+		if guess == code {
+			guess = "999999"
+		}
+		body, _ := json.Marshal(map[string]string{"phone": "+15550100", "code": guess})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/public/login/phone/verify", bytes.NewReader(body)))
+			statuses <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusUnauthorized] != maxPhoneOTPAttempts || counts[http.StatusTooManyRequests] != guesses-maxPhoneOTPAttempts {
+		t.Fatalf("responses to %d parallel wrong codes: %v, want %d rejected and the rest limited",
+			guesses, counts, maxPhoneOTPAttempts)
+	}
+
+	// The code is used up, so even the right one no longer works
+	status, _ := doJSON(t, router, http.MethodPost, "/api/v1/public/login/phone/verify", "", map[string]string{"phone": "+15550100", "code": code})
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("right code after the limit: got %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestPhoneLoginRightCodeDoesNotCount(t *testing.T) {
+	db := newTestDB(t)
+	sender := &recordingSender{}
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"},
+		SMS:               sender,
+		DisableRateLimits: true,
+	})
+	userID := createTestUser(t, db, "Bob", "bob@example.com")
+	if err := repository.MarkPhoneVerified(db, userID, "+15550101"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < PhoneOTPFailureBudget+1; i++ {
+		doJSON(t, router, http.MethodPost, "/api/v1/public/login/phone", "", map[string]string{"phone": "+15550101"})
+		code := sender.lastCode(t)
+		if status, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/login/phone/verify", "",
+			map[string]string{"phone": "+15550101", "code": code}); status != http.StatusOK {
+			t.Fatalf("sign-in %d with the right code: %d %s", i+1, status, resp.Message)
+		}
+	}
+}
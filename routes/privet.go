@@ -3,14 +3,52 @@ package routes
 import (
 	"database/sql"
 	"golang_projects/middleware"
+	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
-// PrivateRoutes registers routes that require authentication
-func PrivateRoutes(r *mux.Router, db *sql.DB) {
+// PrivateRoutes registers routes that require authentication. Each route
+// declares the scope a personal access token needs to reach it.
+func PrivateRoutes(r *mux.Router, db *sql.DB, cfg Config) {
 
-	r.HandleFunc("/users_details", middleware.JWTAuthMiddleware(HandleGetUserByEmail(db))).Methods("GET")
-	r.HandleFunc("/update_user", middleware.JWTAuthMiddleware(HandleUpdateUser(db))).Methods("PUT", "PATCH")
-	r.HandleFunc("/delete_user", middleware.JWTAuthMiddleware(HandleDeleteUser(db))).Methods("DELETE")
+	r.HandleFunc("/get_all_users", requirePermission(db, "users:read", HandleUsers(db, cfg.Policies))).Methods("GET")
+	r.HandleFunc("/users_details", requireScope("profile:read", HandleGetUserByEmail(db, cfg.Policies))).Methods("GET")
+	r.HandleFunc("/update_user", requireScope("profile:write", HandleUpdateUser(db, cfg.Policies, cfg.Mailer, cfg.Passwords))).Methods("PUT", "PATCH")
+	r.HandleFunc("/delete_user", requireScope("account:delete", HandleDeleteUser(db, cfg.Policies))).Methods("DELETE")
+	r.HandleFunc("/me", requireScope("profile:read", HandleMe(db, cfg.Policies, cfg.Mailer, cfg.Passwords))).Methods("GET")
+	r.HandleFunc("/me", requireScope("profile:write", HandleMe(db, cfg.Policies, cfg.Mailer, cfg.Passwords))).Methods("PUT", "PATCH")
+	r.HandleFunc("/phone/verify/send", requireScope("profile:write", HandlePhoneVerificationSend(db, cfg.SMS))).Methods("POST")
+	r.HandleFunc("/phone/verify", requireScope("profile:write", HandlePhoneVerify(db))).Methods("POST")
+	r.HandleFunc("/api_keys", requireScope("api_keys:read", HandleAPIKeys(db))).Methods("GET")
+	r.HandleFunc("/api_keys", requireScope("api_keys:write", HandleAPIKeys(db))).Methods("POST", "DELETE")
+	r.HandleFunc("/mfa/totp/enroll", requireScope("mfa:manage", HandleTOTPEnroll(db))).Methods("POST")
+	r.HandleFunc("/mfa/totp/confirm", requireScope("mfa:manage", HandleTOTPConfirm(db))).Methods("POST")
+	r.HandleFunc("/mfa/totp/disable", requireScope("mfa:manage", HandleTOTPDisable(db))).Methods("POST")
+	r.HandleFunc("/mfa/recovery_codes", requireScope("mfa:manage", HandleRecoveryCodes(db))).Methods("GET", "POST")
+	if cfg.WebAuthn != nil {
+		r.HandleFunc("/webauthn/register/begin", requireScope("mfa:manage", HandleWebAuthnRegisterBegin(db, cfg.WebAuthn))).Methods("POST")
+		r.HandleFunc("/webauthn/register/finish", requireScope("mfa:manage", HandleWebAuthnRegisterFinish(db, cfg.WebAuthn))).Methods("POST")
+		r.HandleFunc("/webauthn/credentials", requireScope("mfa:manage", HandleWebAuthnCredentials(db))).Methods("GET", "DELETE")
+	}
+	r.HandleFunc("/logout", middleware.JWTAuthMiddleware(HandleLogout(db))).Methods("POST")
+}
+
+// routeAPIScopes maps route scopes to the API scope (see apiScopes) that also
+// grants them, so service clients and OAuth tokens granted an API scope reach
+// the matching routes. Other route scopes can only be held by personal access tokens.
+var routeAPIScopes = map[string]string{
+	"profile:read":   "users:read",
+	"profile:write":  "users:write",
+	"account:delete": "users:delete",
+}
+
+// requireScope authenticates the request and requires the scope from every
+// scope-restricted principal (personal access tokens and OAuth-issued tokens)
+func requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	scopes := []string{scope}
+	if apiScope, ok := routeAPIScopes[scope]; ok {
+		scopes = append(scopes, apiScope)
+	}
+	return middleware.JWTAuthMiddleware(middleware.RequireScope(scopes...)(handler))
 }
@@ -7,8 +7,23 @@ import (
 )
 
 // PublicRoutes registers routes accessible without authentication
-func PublicRoutes(r *mux.Router, db *sql.DB) {
-	r.HandleFunc("/register", HandleRegister(db)).Methods("POST")
+func PublicRoutes(r *mux.Router, db *sql.DB, cfg Config) {
+	r.HandleFunc("/register", HandleRegister(db, cfg.Mailer, cfg.Passwords)).Methods("POST")
+	r.HandleFunc("/verify_email", HandleVerifyEmail(db)).Methods("GET", "POST")
+	r.HandleFunc("/verify_email/resend", HandleResendVerification(db, cfg.Mailer)).Methods("POST")
 	r.HandleFunc("/login", HandleLogin(db)).Methods("POST")
-	r.HandleFunc("/get_all_users", HandleUsers(db)).Methods("GET")
+	r.HandleFunc("/login/magic", HandleMagicLinkRequest(db, cfg.Mailer)).Methods("POST")
+	r.HandleFunc("/login/magic/verify", HandleMagicLinkLogin(db)).Methods("GET", "POST")
+	r.HandleFunc("/login/phone", HandlePhoneLoginRequest(db, cfg.SMS)).Methods("POST")
+	r.HandleFunc("/login/phone/verify", HandlePhoneLogin(db)).Methods("POST")
+	r.HandleFunc("/login/mfa", HandleLoginMFA(db, cfg.WebAuthn)).Methods("POST")
+	r.HandleFunc("/password/forgot", HandleForgotPassword(db, cfg.Mailer)).Methods("POST")
+	r.HandleFunc("/password/reset", HandleResetPassword(db, cfg.Passwords)).Methods("POST")
+	r.HandleFunc("/refresh", HandleRefresh(db)).Methods("POST")
+
+	if cfg.WebAuthn != nil {
+		r.HandleFunc("/login/webauthn/begin", HandleWebAuthnLoginBegin(db, cfg.WebAuthn)).Methods("POST")
+		r.HandleFunc("/login/webauthn/finish", HandleWebAuthnLoginFinish(db, cfg.WebAuthn)).Methods("POST")
+		r.HandleFunc("/login/mfa/webauthn", HandleMFAWebAuthnBegin(db, cfg.WebAuthn)).Methods("POST")
+	}
 }
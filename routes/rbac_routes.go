@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// HandleListRoles lists every role with its permissions
+func HandleListRoles(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles, err := repository.GetAllRoles(db)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch roles", nil)
+			log.Printf("GetAllRoles error: %v", err)
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Success", roles)
+	}
+}
+
+// HandleCreateRole creates a role, optionally with an initial set of permissions
+func HandleCreateRole(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name        string   `json:"name"`
+			Description string   `json:"description"`
+			Permissions []string `json:"permissions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+		if req.Name == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "name is required", nil)
+			return
+		}
+
+		if err := repository.CreateRole(db, req.Name, req.Description); err != nil {
+			utils.WriteJSONResponse(w, http.StatusConflict, false, err.Error(), nil)
+			log.Printf("Create role error: %v", err)
+			return
+		}
+
+		for _, permission := range req.Permissions {
+			granted, err := repository.GrantPermissionToRole(db, req.Name, permission)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to grant permission", nil)
+				log.Printf("Grant permission error: %v", err)
+				return
+			}
+			if granted == 0 {
+				log.Printf("Permission %s not granted to role %s: unknown permission", permission, req.Name)
+			}
+		}
+
+		utils.WriteJSONResponse(w, http.StatusCreated, true, "Role created successfully", nil)
+	}
+}
+
+// HandleListPermissions lists every permission
+func HandleListPermissions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := repository.GetAllPermissions(db)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch permissions", nil)
+			log.Printf("GetAllPermissions error: %v", err)
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Success", permissions)
+	}
+}
+
+// HandleRolePermission grants (POST) or revokes (DELETE) a permission on a role
+func HandleRolePermission(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Role       string `json:"role"`
+			Permission string `json:"permission"`
+		}
+		if r.Method == http.MethodDelete {
+			req.Role = r.URL.Query().Get("role")
+			req.Permission = r.URL.Query().Get("permission")
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+		if req.Role == "" || req.Permission == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "role and permission are required", nil)
+			return
+		}
+
+		var (
+			rowsAffected int64
+			err          error
+		)
+		switch r.Method {
+		case http.MethodPost:
+			rowsAffected, err = repository.GrantPermissionToRole(db, req.Role, req.Permission)
+		case http.MethodDelete:
+			rowsAffected, err = repository.RevokePermissionFromRole(db, req.Role, req.Permission)
+		default:
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to update role permissions", nil)
+			log.Printf("Role permission error: %v", err)
+			return
+		}
+
+		if rowsAffected == 0 && r.Method == http.MethodDelete {
+			utils.WriteJSONResponse(w, http.StatusNotFound, false, "Role permission not found", nil)
+			return
+		}
+		if rowsAffected == 0 {
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Role already has the permission or role/permission not found", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Role permissions updated successfully", nil)
+		log.Printf("Role %s permission %s updated (%s)", req.Role, req.Permission, r.Method)
+	}
+}
+
+// HandleUserRoles lists (GET), assigns (POST) or removes (DELETE) a user's roles.
+// Changes apply to tokens issued after the change.
+func HandleUserRoles(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserID int    `json:"user_id"`
+			Role   string `json:"role"`
+		}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+				return
+			}
+		} else {
+			userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid User ID", nil)
+				return
+			}
+			req.UserID = userID
+			req.Role = r.URL.Query().Get("role")
+		}
+
+		if r.Method == http.MethodGet {
+			roles, err := repository.GetUserRoles(db, req.UserID)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch roles", nil)
+				log.Printf("GetUserRoles error: %v", err)
+				return
+			}
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", roles)
+			return
+		}
+
+		if req.UserID == 0 || req.Role == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "user_id and role are required", nil)
+			return
+		}
+
+		var (
+			rowsAffected int64
+			err          error
+		)
+		switch r.Method {
+		case http.MethodPost:
+			rowsAffected, err = repository.AssignRoleToUser(db, req.UserID, req.Role)
+		case http.MethodDelete:
+			rowsAffected, err = repository.RemoveRoleFromUser(db, req.UserID, req.Role)
+		default:
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to update user roles", nil)
+			log.Printf("User role error: %v", err)
+			return
+		}
+
+		if rowsAffected == 0 && r.Method == http.MethodDelete {
+			utils.WriteJSONResponse(w, http.StatusNotFound, false, "Role assignment not found", nil)
+			return
+		}
+		if rowsAffected == 0 {
+			utils.WriteJSONResponse(w, http.StatusOK, true, "User already has the role or user/role not found", nil)
+			return
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "User roles updated successfully", nil)
+		log.Printf("User %d role %s updated (%s)", req.UserID, req.Role, r.Method)
+	}
+}
@@ -0,0 +1,153 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"golang_projects/middleware"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+)
+
+// RecoveryCodeCount is the number of recovery codes issued at a time
+const RecoveryCodeCount = 10
+
+// generateRecoveryCodes replaces the user's recovery codes with a new set and
+// returns them; only their hashes are stored
+func generateRecoveryCodes(db *sql.DB, userID int) ([]string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, utils.HashToken(utils.NormalizeRecoveryCode(code)))
+	}
+
+	if err := repository.ReplaceRecoveryCodes(db, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ensureRecoveryCodes issues recovery codes when a user enrolls their first
+// second factor. It returns nil when the user still has unused codes.
+func ensureRecoveryCodes(db *sql.DB, userID int) ([]string, error) {
+	remaining, err := repository.CountRecoveryCodes(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if remaining > 0 {
+		return nil, nil
+	}
+	return generateRecoveryCodes(db, userID)
+}
+
+// recoveryCodesResponse is the response data of an enrollment that issued
+// recovery codes, or nil when none were issued
+func recoveryCodesResponse(codes []string) interface{} {
+	if len(codes) == 0 {
+		return nil
+	}
+	return map[string][]string{"recovery_codes": codes}
+}
+
+// verifyRecoveryCode consumes one of the user's recovery codes
+func verifyRecoveryCode(db *sql.DB, userID int, code string) (bool, error) {
+	code = utils.NormalizeRecoveryCode(code)
+	if code == "" {
+		return false, nil
+	}
+	return repository.UseRecoveryCode(db, userID, utils.HashToken(code))
+}
+
+// clearRecoveryCodesIfMFADisabled removes recovery codes once the user has no
+// second factor left, so they cannot be used to bypass a later enrollment
+func clearRecoveryCodesIfMFADisabled(db *sql.DB, userID int) {
+	methods, err := mfaMethods(db, userID)
+	if err != nil {
+		log.Printf("GetUserMFA error: %v", err)
+		return
+	}
+	if len(methods) > 0 {
+		return
+	}
+	if err := repository.DeleteRecoveryCodes(db, userID); err != nil {
+		log.Printf("Delete recovery codes error: %v", err)
+	}
+}
+
+// HandleRecoveryCodes returns the number of unused recovery codes (GET) or
+// regenerates them (POST), which invalidates the previous set and requires
+// the account password
+func HandleRecoveryCodes(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			remaining, err := repository.CountRecoveryCodes(db, principal.UserID)
+			if err != nil {
+				log.Printf("CountRecoveryCodes error: %v", err)
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch recovery codes", nil)
+				return
+			}
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", map[string]int{"remaining": remaining})
+		case http.MethodPost:
+			regenerateRecoveryCodes(w, r, db, principal)
+		default:
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
+
+// regenerateRecoveryCodes issues a new set of recovery codes after re-authentication
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, db *sql.DB, principal middleware.Principal) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+
+	user, err := repository.GetUserByID(db, principal.UserID)
+	if err != nil {
+		log.Printf("GetUserByID error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to regenerate recovery codes", nil)
+		return
+	}
+	if _, err := authenticatePassword(db, user.Email, req.Password); err != nil {
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid password", nil)
+		return
+	}
+
+	methods, err := mfaMethods(db, user.ID)
+	if err != nil {
+		log.Printf("GetUserMFA error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to regenerate recovery codes", nil)
+		return
+	}
+	if len(methods) == 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "MFA is not enabled", nil)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(db, user.ID)
+	if err != nil {
+		log.Printf("Generate recovery codes error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to regenerate recovery codes", nil)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, true, "Recovery codes regenerated; previous codes no longer work",
+		map[string][]string{"recovery_codes": codes})
+	log.Printf("User %d regenerated recovery codes", user.ID)
+}
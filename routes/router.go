@@ -2,24 +2,138 @@ package routes
 
 import (
 	"database/sql"
+	"golang_projects/mailer"
+	"golang_projects/password"
+	"golang_projects/policy"
+	"golang_projects/ratelimit"
+	"golang_projects/sms"
+	"net/http"
+	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/mux"
 )
 
+// Config holds the pluggable dependencies used by the handlers
+type Config struct {
+	// Policies are consulted before acting on user records; nil means no policies
+	Policies policy.Evaluator
+	// WebAuthn is the relying party for passkeys and security keys; nil disables them
+	WebAuthn *webauthn.WebAuthn
+	// Mailer sends verification and other account email; nil writes to ./outbox
+	Mailer mailer.Mailer
+	// Passwords are the rules new passwords must satisfy; nil means password.DefaultPolicy
+	Passwords *password.Policy
+	// SMS sends one-time codes by text message; nil writes them to the log
+	SMS sms.Sender
+	// RateLimitStore holds the rate limit counters; nil keeps them in memory
+	RateLimitStore ratelimit.Store
+	// ClientIP resolves client addresses behind trusted proxies; nil trusts no proxy
+	ClientIP *ratelimit.IPResolver
+	// DisableRateLimits turns off every rate limit, e.g. for load tests
+	DisableRateLimits bool
+}
+
 // SetupRoutes initializes all routes
-func SetupRoutes(db *sql.DB) *mux.Router {
+func SetupRoutes(db *sql.DB, cfg Config) *mux.Router {
+	if cfg.Policies == nil {
+		cfg.Policies, _ = policy.NewEngine(nil)
+	}
+	if cfg.Mailer == nil {
+		cfg.Mailer = mailer.OutboxMailer{Dir: "outbox", From: "no-reply@localhost"}
+	}
+	if cfg.Passwords == nil {
+		cfg.Passwords = password.DefaultPolicy()
+	}
+	if cfg.SMS == nil {
+		cfg.SMS = sms.LogSender{}
+	}
+
+	if cfg.RateLimitStore == nil {
+		cfg.RateLimitStore = ratelimit.NewMemoryStore()
+	}
+
 	router := mux.NewRouter()
+	if !cfg.DisableRateLimits {
+		router.Use(rateLimitMiddleware(cfg))
+	}
+
+	// Discovery documents and OpenID Connect provider endpoints at the site root
+	WellKnownRoutes(router)
+	OIDCRoutes(router, db)
 
 	// API v1 routes
 	apiV1 := router.PathPrefix("/api/v1").Subrouter()
 
 	// Public routes
 	public := apiV1.PathPrefix("/public").Subrouter()
-	PublicRoutes(public, db)
+	PublicRoutes(public, db, cfg)
 
 	// Private routes (Require JWT Auth)
 	private := apiV1.PathPrefix("/mobile").Subrouter()
-	PrivateRoutes(private, db)
+	PrivateRoutes(private, db, cfg)
+
+	// Admin routes
+	admin := apiV1.PathPrefix("/admin").Subrouter()
+	AdminRoutes(admin, db)
 
 	return router
 }
+
+// rateLimitRules returns the rate limits of each route, by path template. A
+// request is rejected when any rule of its route is exhausted; rules with the
+// same name share one budget across routes.
+func rateLimitRules(clientIP *ratelimit.IPResolver) map[string][]ratelimit.Rule {
+	byIP := ratelimit.ByIP(clientIP)
+
+	perIP := func(name string, limit int, window time.Duration) ratelimit.Rule {
+		return ratelimit.Rule{Name: name, Limit: limit, Window: window, Key: byIP}
+	}
+	// Messages sent to an address or number, so they cannot be used to spam it
+	emails := ratelimit.Rule{Name: "email_messages", Limit: 5, Window: time.Hour, Key: ratelimit.ByField("email")}
+	texts := ratelimit.Rule{Name: "sms_messages", Limit: 5, Window: time.Hour, Key: ratelimit.ByField("phone")}
+	userTexts := ratelimit.Rule{Name: "sms_messages_user", Limit: 5, Window: time.Hour, Key: ratelimit.ByUser()}
+	codes := ratelimit.Rule{Name: "codes_user", Limit: 10, Window: time.Minute, Key: ratelimit.ByUser()}
+
+	return map[string][]ratelimit.Rule{
+		"/api/v1/public/register":             {perIP("register", 10, time.Hour)},
+		"/api/v1/public/login":                {perIP("login", 30, time.Minute), {Name: "login_email", Limit: 10, Window: 15 * time.Minute, Key: ratelimit.ByField("email")}},
+		"/api/v1/public/login/mfa":            {perIP("login_mfa", 30, time.Minute)},
+		"/api/v1/public/login/magic":          {perIP("send_email", 10, time.Minute), emails},
+		"/api/v1/public/login/magic/verify":   {perIP("verify_link", 30, time.Minute)},
+		"/api/v1/public/login/phone":          {perIP("send_sms", 10, time.Minute), texts},
+		"/api/v1/public/login/phone/verify":   {perIP("login_phone", 30, time.Minute)},
+		"/api/v1/public/login/webauthn/begin": {perIP("login_webauthn", 30, time.Minute)},
+		"/api/v1/public/password/forgot":      {perIP("send_email", 10, time.Minute), emails},
+		"/api/v1/public/password/reset":       {perIP("password_reset", 10, time.Minute)},
+		"/api/v1/public/verify_email":         {perIP("verify_link", 30, time.Minute)},
+		"/api/v1/public/verify_email/resend":  {perIP("send_email", 10, time.Minute), emails},
+		"/api/v1/public/refresh":              {perIP("refresh", 60, time.Minute)},
+		"/api/v1/mobile/phone/verify/send":    {userTexts},
+		"/api/v1/mobile/phone/verify":         {codes},
+		"/api/v1/mobile/mfa/totp/confirm":     {codes},
+		"/api/v1/mobile/mfa/totp/disable":     {codes},
+		"/authorize":                          {perIP("authorize", 30, time.Minute)},
+		"/token":                              {perIP("token", 60, time.Minute)},
+	}
+}
+
+// rateLimitMiddleware applies the rate limits of the matched route
+func rateLimitMiddleware(cfg Config) mux.MiddlewareFunc {
+	limiter := &ratelimit.Limiter{Store: cfg.RateLimitStore}
+	rules := rateLimitRules(cfg.ClientIP)
+
+	// mux applies middleware to the matched handler on every request, so only
+	// the matched route's handler is wrapped
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil {
+				if path, err := route.GetPathTemplate(); err == nil && len(rules[path]) > 0 {
+					limiter.Handler(rules[path], next).ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"golang_projects/database"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of users created by createTestUser
+const testPassword = "secret!1"
+
+func TestMain(m *testing.M) {
+	if err := utils.InitJWTKeys(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	os.Exit(m.Run())
+}
+
+// apiResponse is the envelope written by utils.WriteJSONResponse
+type apiResponse struct {
+	Status  bool            `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// newTestDB opens a fresh database in a temporary directory
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := database.Open("file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on&_busy_timeout=5000")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createTestUser stores a user whose email is already verified and returns its id
+func createTestUser(t *testing.T, db *sql.DB, name, email string) int {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repository.CreateUser(db, model.User{Name: name, Email: email, Password: string(hash), Phone: "1234567890", Address: "street"})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	user, err := repository.GetUserByEmail(db, email)
+	if err != nil {
+		t.Fatalf("get user: %v", err)
+	}
+	if _, err := repository.MarkEmailVerified(db, user.ID, email); err != nil {
+		t.Fatalf("verify email: %v", err)
+	}
+	return user.ID
+}
+
+// doJSON sends body as JSON to the handler and decodes the response envelope
+func doJSON(t *testing.T, h http.Handler, method, path, token string, body interface{}) (int, apiResponse) {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp apiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: invalid JSON response %q: %v", method, path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+// decodeData decodes the data of a response envelope
+func decodeData(t *testing.T, resp apiResponse, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		t.Fatalf("decode %s: %v", resp.Data, err)
+	}
+}
+
+// loginTokens signs in with email and testPassword and returns the access token
+func loginTokens(t *testing.T, h http.Handler, email string) string {
+	t.Helper()
+	code, resp := doJSON(t, h, http.MethodPost, "/api/v1/public/login", "", map[string]string{"email": email, "password": testPassword})
+	if code != http.StatusOK {
+		t.Fatalf("login: %d %s", code, resp.Message)
+	}
+	var tokens TokenResponse
+	decodeData(t, resp, &tokens)
+	if tokens.AccessToken == "" {
+		t.Fatalf("login returned no access token: %s", resp.Data)
+	}
+	return tokens.AccessToken
+}
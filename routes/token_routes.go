@@ -0,0 +1,226 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TokenResponse is the token payload returned by login and refresh
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// issueTokenPair creates an access token and a refresh token in a new family
+func issueTokenPair(db *sql.DB, userID int) (TokenResponse, error) {
+	accessToken, err := generateUserAccessToken(db, userID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	refreshToken, err := createRefreshToken(db, userID, "", "")
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// generateUserAccessToken issues an access token with the user's current roles
+func generateUserAccessToken(db *sql.DB, userID int) (string, error) {
+	roles, err := repository.GetUserRoles(db, userID)
+	if err != nil {
+		return "", err
+	}
+	return utils.GenerateJWT(userID, roles)
+}
+
+// createRefreshToken stores a new refresh token in a new family, optionally
+// bound to an OAuth client and scope
+func createRefreshToken(db *sql.DB, userID int, clientID, scope string) (string, error) {
+	familyID, err := utils.GenerateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := model.RefreshToken{
+		UserID:    userID,
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+		ClientID:  sql.NullString{String: clientID, Valid: clientID != ""},
+		Scope:     sql.NullString{String: scope, Valid: scope != ""},
+	}
+	if err := repository.CreateRefreshToken(db, record); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// errInvalidRefreshToken is returned for unknown, expired, reused or foreign refresh tokens
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
+// rotateRefreshToken exchanges a refresh token for a new one in the same family.
+// Presenting a token that was already rotated revokes its whole family. The
+// token must have been issued to clientID ("" for first-party logins).
+func rotateRefreshToken(db *sql.DB, presented, clientID string) (model.RefreshToken, string, error) {
+	current, err := repository.GetRefreshTokenByHash(db, utils.HashToken(presented))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return model.RefreshToken{}, "", err
+		}
+		return model.RefreshToken{}, "", errInvalidRefreshToken
+	}
+
+	if current.ClientID.String != clientID {
+		return model.RefreshToken{}, "", errInvalidRefreshToken
+	}
+
+	if current.RevokedAt.Valid {
+		revokeFamilyOnReuse(db, current.FamilyID, current.UserID)
+		return model.RefreshToken{}, "", errInvalidRefreshToken
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return model.RefreshToken{}, "", errInvalidRefreshToken
+	}
+
+	refreshToken, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return model.RefreshToken{}, "", err
+	}
+
+	err = repository.RotateRefreshToken(db, current, utils.HashToken(refreshToken), time.Now().Add(utils.RefreshTokenTTL))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenReused) {
+			revokeFamilyOnReuse(db, current.FamilyID, current.UserID)
+			return model.RefreshToken{}, "", errInvalidRefreshToken
+		}
+		return model.RefreshToken{}, "", err
+	}
+
+	return current, refreshToken, nil
+}
+
+// HandleRefresh rotates a refresh token and returns a new token pair.
+// Presenting a token that was already rotated revokes its whole family.
+func HandleRefresh(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "refresh_token is required", nil)
+			return
+		}
+
+		current, refreshToken, err := rotateRefreshToken(db, req.RefreshToken, "")
+		if err != nil {
+			if errors.Is(err, errInvalidRefreshToken) {
+				utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid refresh token", nil)
+				return
+			}
+			log.Printf("Refresh token rotation error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to refresh token", nil)
+			return
+		}
+
+		accessToken, err := generateUserAccessToken(db, current.UserID)
+		if err != nil {
+			log.Printf("JWT generation error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to generate token", nil)
+			return
+		}
+
+		response := TokenResponse{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Token refreshed successfully", response)
+	}
+}
+
+// revokeFamilyOnReuse revokes every token descended from the same login
+func revokeFamilyOnReuse(db *sql.DB, familyID string, userID int) {
+	revoked, err := repository.RevokeRefreshTokenFamily(db, familyID)
+	if err != nil {
+		log.Printf("Failed to revoke refresh token family %s: %v", familyID, err)
+		return
+	}
+	log.Printf("Refresh token reuse detected for user %d, revoked %d tokens in family %s", userID, revoked, familyID)
+}
+
+// HandleLogout revokes the caller's access token and, if supplied, the refresh token family
+func HandleLogout(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+			return
+		}
+
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Unauthorized", nil)
+			return
+		}
+		if principal.IsAPIKey() {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "API keys are revoked through /api_keys, not logout", nil)
+			return
+		}
+
+		if err := repository.RevokeToken(db, principal.TokenID, principal.ExpiresAt); err != nil {
+			log.Printf("Revoke token error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to logout", nil)
+			return
+		}
+
+		// The refresh token is optional; when present its whole family is revoked
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+				return
+			}
+		}
+		if req.RefreshToken != "" {
+			refresh, err := repository.GetRefreshTokenByHash(db, utils.HashToken(req.RefreshToken))
+			if err == nil && principal.IsUser() && refresh.UserID == principal.UserID {
+				if _, err := repository.RevokeRefreshTokenFamily(db, refresh.FamilyID); err != nil {
+					log.Printf("Revoke refresh token family error: %v", err)
+				}
+			}
+		}
+
+		utils.WriteJSONResponse(w, http.StatusOK, true, "Logged out successfully", nil)
+	}
+}
@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"database/sql"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/policy"
+	"golang_projects/repository"
+	"log"
+)
+
+// userActionPermissions maps policy actions on user records to the RBAC
+// permission that grants them when no policy applies
+var userActionPermissions = map[string]string{
+	"user:read":       "users:read",
+	"user:read_phone": "users:read",
+	"user:update":     "users:write",
+	"user:delete":     "users:delete",
+}
+
+// authorizeUserAction decides whether the principal may perform action on the
+// target user. Attribute-based policies are consulted first (deny overrides);
+// when none applies, ownership and role permissions decide.
+func authorizeUserAction(db *sql.DB, policies policy.Evaluator, p middleware.Principal, target model.User, action string) bool {
+	// Personal access tokens only ever reach the owner's own account
+	if p.IsAPIKey() && p.IsScopeRestricted() && p.UserID != target.ID {
+		return false
+	}
+
+	decision := policies.Evaluate(policy.Request{
+		Subject:  subjectAttributes(db, p),
+		Resource: userAttributes(target),
+		Action:   action,
+	})
+
+	switch decision.Effect {
+	case policy.Deny:
+		log.Printf("Policy %s denied %s on user %d", decision.PolicyID, action, target.ID)
+		return false
+	case policy.Allow:
+		return true
+	}
+	return userAccessAllowed(db, p, target.ID, userActionPermissions[action])
+}
+
+// userAccessAllowed reports whether the principal may act on the target user's
+// record: users may always act on their own account, everything else needs
+// the permission for the action.
+func userAccessAllowed(db *sql.DB, p middleware.Principal, targetUserID int, permission string) bool {
+	if p.IsUser() && p.UserID == targetUserID {
+		return true
+	}
+	return middleware.HasPermission(db, p, permission)
+}
+
+// subjectAttributes describes the principal for policy evaluation
+func subjectAttributes(db *sql.DB, p middleware.Principal) policy.Attributes {
+	attrs := policy.Attributes{
+		"type":      p.SubjectType,
+		"client_id": p.ClientID,
+		"roles":     p.Roles,
+		"scopes":    p.Scopes,
+	}
+	if p.IsUser() {
+		attrs["id"] = p.UserID
+		if user, err := repository.GetUserByID(db, p.UserID); err == nil {
+			attrs["region"] = user.Region
+		}
+	}
+	return attrs
+}
+
+// userAttributes describes a user record for policy evaluation
+func userAttributes(user model.User) policy.Attributes {
+	return policy.Attributes{
+		"id":     user.ID,
+		"email":  user.Email,
+		"region": user.Region,
+	}
+}
+
+// targetUser loads the user a request acts on; unknown ids yield a record with
+// only the id so authorization does not reveal whether the account exists
+func targetUser(db *sql.DB, userID int) model.User {
+	user, err := repository.GetUserByID(db, userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("GetUserByID error: %v", err)
+		}
+		return model.User{ID: userID}
+	}
+	return user
+}
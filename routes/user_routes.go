@@ -2,14 +2,20 @@ package routes
 
 import (
 	"database/sql"
+	"golang_projects/mailer"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/password"
+	"golang_projects/policy"
 	"golang_projects/repository"
 	utils "golang_projects/utility"
 	"log"
 	"net/http"
 )
 
-// HandleUsers handles user-related API requests
-func HandleUsers(db *sql.DB) http.HandlerFunc {
+// HandleUsers lists the users the caller may view. Policies can still deny
+// individual records, and phone numbers are redacted as in HandleGetUserByEmail.
+func HandleUsers(db *sql.DB, policies policy.Evaluator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			users, err := repository.GetAllUsers(db)
@@ -18,14 +24,29 @@ func HandleUsers(db *sql.DB) http.HandlerFunc {
 				log.Printf("GetUsers error: %v", err)
 				return
 			}
-			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", users)
+
+			principal, _ := middleware.PrincipalFromRequest(r)
+			visible := make([]model.User, 0, len(users))
+			for _, user := range users {
+				if !authorizeUserAction(db, policies, principal, user, "user:read") {
+					continue
+				}
+				if !authorizeUserAction(db, policies, principal, user, "user:read_phone") {
+					user.Phone = ""
+				}
+				visible = append(visible, user)
+			}
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", visible)
 			return
 		} else {
 			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
 		}
 	}
 }
-func HandleGetUserByEmail(db *sql.DB) http.HandlerFunc {
+
+// HandleGetUserByEmail returns a user's details. Callers may view their own
+// account; anything else is decided by policies and role permissions.
+func HandleGetUserByEmail(db *sql.DB, policies policy.Evaluator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		email := r.URL.Query().Get("email")
 		if email == "" {
@@ -34,13 +55,69 @@ func HandleGetUserByEmail(db *sql.DB) http.HandlerFunc {
 		}
 
 		user, err := repository.GetUserByEmail(db, email)
-		if err != nil {
+		if err != nil && err != sql.ErrNoRows {
 			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch user", nil)
 			log.Printf("GetUserByEmail error: %v", err)
 			return
 		}
 
+		// Authorize before revealing whether the account exists
+		principal, _ := middleware.PrincipalFromRequest(r)
+		target := user
+		if err == sql.ErrNoRows {
+			target = model.User{Email: email}
+		}
+		if !authorizeUserAction(db, policies, principal, target, "user:read") {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "You are not allowed to view this account", nil)
+			return
+		}
+		if err == sql.ErrNoRows {
+			utils.WriteJSONResponse(w, http.StatusNotFound, false, "User not found", nil)
+			return
+		}
+
+		if !authorizeUserAction(db, policies, principal, user, "user:read_phone") {
+			user.Phone = ""
+		}
+
 		utils.WriteJSONResponse(w, http.StatusOK, true, "Success", user)
 	}
 }
 
+// HandleMe returns (GET) or edits (PUT/PATCH) the caller's own profile
+func HandleMe(db *sql.DB, policies policy.Evaluator, m mailer.Mailer, passwords *password.Policy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "Only user accounts have a profile", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			user, err := repository.GetUserByID(db, principal.UserID)
+			if err == sql.ErrNoRows {
+				utils.WriteJSONResponse(w, http.StatusNotFound, false, "User not found", nil)
+				return
+			}
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch user", nil)
+				log.Printf("GetUserByID error: %v", err)
+				return
+			}
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", user)
+		case http.MethodPut, http.MethodPatch:
+			self := model.User{ID: principal.UserID}
+			if user, err := repository.GetUserByID(db, principal.UserID); err == nil {
+				self = user
+			}
+			if !authorizeUserAction(db, policies, principal, self, "user:update") {
+				utils.WriteJSONResponse(w, http.StatusForbidden, false, "You are not allowed to update this account", nil)
+				return
+			}
+			updateUser(w, r, db, m, passwords, principal.UserID)
+		default:
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
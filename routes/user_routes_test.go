@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"golang_projects/mailer"
+	"golang_projects/model"
+	"golang_projects/repository"
+	"net/http"
+	"testing"
+)
+
+func TestListUsersRequiresPermission(t *testing.T) {
+	db := newTestDB(t)
+	router := SetupRoutes(db, Config{
+		Mailer:            mailer.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"},
+		DisableRateLimits: true,
+	})
+	adminID := createTestUser(t, db, "Alice", "alice@example.com")
+	createTestUser(t, db, "Bob", "bob@example.com")
+	if _, err := repository.AssignRoleToUser(db, adminID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := doJSON(t, router, http.MethodGet, "/api/v1/mobile/get_all_users", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous list: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	bobToken := loginTokens(t, router, "bob@example.com")
+	if code, _ := doJSON(t, router, http.MethodGet, "/api/v1/mobile/get_all_users", bobToken, nil); code != http.StatusForbidden {
+		t.Fatalf("list without users:read: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	adminToken := loginTokens(t, router, "alice@example.com")
+	code, resp := doJSON(t, router, http.MethodGet, "/api/v1/mobile/get_all_users", adminToken, nil)
+	if code != http.StatusOK {
+		t.Fatalf("list with users:read: %d %s", code, resp.Message)
+	}
+	var users []model.User
+	decodeData(t, resp, &users)
+	if len(users) != 2 {
+		t.Fatalf("got %d users, want 2", len(users))
+	}
+	for _, user := range users {
+		if user.Phone == "" {
+			t.Fatalf("phone of user %d redacted for a caller holding users:read", user.ID)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"golang_projects/middleware"
+	"golang_projects/model"
+	"golang_projects/repository"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// WebAuthnSessionTTL is how long a registration or assertion challenge can be answered
+var WebAuthnSessionTTL = utils.GetEnvDuration("WEBAUTHN_SESSION_TTL", 5*time.Minute)
+
+// webAuthnUser adapts a user and their credentials to webauthn.User
+type webAuthnUser struct {
+	user        model.User
+	credentials []model.WebAuthnCredential
+}
+
+// WebAuthnID returns the user handle; it is the user id and carries no personal data
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.Itoa(u.user.ID))
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		credentials = append(credentials, c.Credential)
+	}
+	return credentials
+}
+
+// loadWebAuthnUser loads a user with their registered credentials
+func loadWebAuthnUser(db *sql.DB, userID int) (*webAuthnUser, error) {
+	user, err := repository.GetUserByID(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := repository.GetWebAuthnCredentialsByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// startWebAuthnSession stores a ceremony's state and writes the options for
+// the browser together with the session token that must accompany the answer
+func startWebAuthnSession(w http.ResponseWriter, db *sql.DB, userID int, purpose string, data *webauthn.SessionData, options interface{}) {
+	token, err := utils.GenerateOpaqueToken(32)
+	if err != nil {
+		log.Printf("WebAuthn session token generation error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+		return
+	}
+
+	err = repository.CreateWebAuthnSession(db, model.WebAuthnSession{
+		TokenHash: utils.HashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      *data,
+		ExpiresAt: time.Now().Add(WebAuthnSessionTTL),
+	})
+	if err != nil {
+		log.Printf("Create WebAuthn session error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+		return
+	}
+
+	response := struct {
+		SessionToken string      `json:"session_token"`
+		Options      interface{} `json:"options"`
+	}{
+		SessionToken: token,
+		Options:      options,
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, true, "Success", response)
+}
+
+// recordAssertion persists the credential state after a verified assertion.
+// It returns false when the sign counter suggests a cloned authenticator.
+func recordAssertion(db *sql.DB, credential *webauthn.Credential) bool {
+	if credential.Authenticator.CloneWarning {
+		log.Printf("WebAuthn credential %x may be cloned: sign counter went backwards", credential.ID)
+		return false
+	}
+	if err := repository.UpdateWebAuthnCredentialUse(db, *credential); err != nil {
+		log.Printf("Update WebAuthn credential error: %v", err)
+	}
+	return true
+}
+
+// logWebAuthnError logs the detailed reason a ceremony failed
+func logWebAuthnError(ceremony string, err error) {
+	if perr, ok := err.(*protocol.Error); ok {
+		log.Printf("WebAuthn %s failed: %s: %s", ceremony, perr.Details, perr.DevInfo)
+		return
+	}
+	log.Printf("WebAuthn %s failed: %v", ceremony, err)
+}
+
+// HandleWebAuthnRegisterBegin starts registering a passkey or security key
+// for the caller. Attestation is not requested ("none").
+func HandleWebAuthnRegisterBegin(db *sql.DB, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		user, err := loadWebAuthnUser(db, principal.UserID)
+		if err != nil {
+			log.Printf("Load WebAuthn user error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+			return
+		}
+
+		exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+		for _, c := range user.credentials {
+			exclusions = append(exclusions, c.Credential.Descriptor())
+		}
+
+		creation, session, err := wa.BeginRegistration(user,
+			webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+			webauthn.WithExclusions(exclusions),
+		)
+		if err != nil {
+			logWebAuthnError("registration", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+			return
+		}
+
+		startWebAuthnSession(w, db, user.user.ID, model.WebAuthnPurposeRegistration, session, creation)
+	}
+}
+
+// HandleWebAuthnRegisterFinish verifies the authenticator's response and stores the credential
+func HandleWebAuthnRegisterFinish(db *sql.DB, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		var req struct {
+			SessionToken string          `json:"session_token"`
+			Name         string          `json:"name"`
+			Credential   json.RawMessage `json:"credential"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+		if req.Name == "" {
+			req.Name = "Passkey"
+		}
+
+		session, err := repository.ConsumeWebAuthnSession(db, utils.HashToken(req.SessionToken), model.WebAuthnPurposeRegistration)
+		if err != nil && err != sql.ErrNoRows {
+			log.Printf("Consume WebAuthn session error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to register credential", nil)
+			return
+		}
+		if err == sql.ErrNoRows || session.UserID != principal.UserID {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid or expired session_token", nil)
+			return
+		}
+
+		user, err := loadWebAuthnUser(db, principal.UserID)
+		if err != nil {
+			log.Printf("Load WebAuthn user error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to register credential", nil)
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+		if err != nil {
+			logWebAuthnError("registration", err)
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid credential", nil)
+			return
+		}
+		credential, err := wa.CreateCredential(user, session.Data, parsed)
+		if err != nil {
+			logWebAuthnError("registration", err)
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Credential verification failed", nil)
+			return
+		}
+
+		if err := repository.CreateWebAuthnCredential(db, principal.UserID, req.Name, *credential); err != nil {
+			log.Printf("Create WebAuthn credential error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusConflict, false, "Failed to register credential", nil)
+			return
+		}
+
+		// Recovery codes are shown once, when the first second factor is enrolled
+		codes, err := ensureRecoveryCodes(db, principal.UserID)
+		if err != nil {
+			log.Printf("Generate recovery codes error: %v", err)
+		}
+
+		utils.WriteJSONResponse(w, http.StatusCreated, true, "Credential registered successfully", recoveryCodesResponse(codes))
+		log.Printf("User %d registered WebAuthn credential %q", principal.UserID, req.Name)
+	}
+}
+
+// HandleWebAuthnCredentials lists (GET) or removes (DELETE ?id=) the caller's
+// credentials. Removing a credential requires the account password.
+func HandleWebAuthnCredentials(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := middleware.PrincipalFromRequest(r)
+		if !ok || !principal.IsUser() {
+			utils.WriteJSONResponse(w, http.StatusForbidden, false, "MFA is only available to users", nil)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			credentials, err := repository.GetWebAuthnCredentialsByUser(db, principal.UserID)
+			if err != nil {
+				log.Printf("GetWebAuthnCredentialsByUser error: %v", err)
+				utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to fetch credentials", nil)
+				return
+			}
+			utils.WriteJSONResponse(w, http.StatusOK, true, "Success", credentials)
+		case http.MethodDelete:
+			deleteWebAuthnCredential(w, r, db, principal)
+		default:
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, false, "Method not allowed", nil)
+		}
+	}
+}
+
+// deleteWebAuthnCredential removes one of the caller's credentials after re-authentication
+func deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request, db *sql.DB, principal middleware.Principal) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid credential ID", nil)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+		return
+	}
+
+	user, err := repository.GetUserByID(db, principal.UserID)
+	if err != nil {
+		log.Printf("GetUserByID error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to remove credential", nil)
+		return
+	}
+	if _, err := authenticatePassword(db, user.Email, req.Password); err != nil {
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid password", nil)
+		return
+	}
+
+	err = repository.DeleteWebAuthnCredential(db, principal.UserID, id)
+	if err == sql.ErrNoRows {
+		utils.WriteJSONResponse(w, http.StatusNotFound, false, "Credential not found", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Delete WebAuthn credential error: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to remove credential", nil)
+		return
+	}
+
+	clearRecoveryCodesIfMFADisabled(db, principal.UserID)
+
+	utils.WriteJSONResponse(w, http.StatusOK, true, "Credential removed successfully", nil)
+	log.Printf("User %d removed WebAuthn credential %d", principal.UserID, id)
+}
+
+// HandleWebAuthnLoginBegin starts a passwordless login with a discoverable
+// credential (passkey). User verification is required, so the passkey alone
+// satisfies MFA.
+func HandleWebAuthnLoginBegin(db *sql.DB, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		assertion, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			logWebAuthnError("login", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+			return
+		}
+		startWebAuthnSession(w, db, 0, model.WebAuthnPurposeLogin, session, assertion)
+	}
+}
+
+// HandleWebAuthnLoginFinish verifies a passkey assertion and signs the user
+// in. The response is the same as a password login.
+func HandleWebAuthnLoginFinish(db *sql.DB, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SessionToken string          `json:"session_token"`
+			Credential   json.RawMessage `json:"credential"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+
+		session, err := repository.ConsumeWebAuthnSession(db, utils.HashToken(req.SessionToken), model.WebAuthnPurposeLogin)
+		if err == sql.ErrNoRows {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid or expired session_token", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("Consume WebAuthn session error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to verify credential", nil)
+			return
+		}
+
+		parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+		if err != nil {
+			logWebAuthnError("login", err)
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid credential", nil)
+			return
+		}
+
+		findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.Atoi(string(userHandle))
+			if err != nil {
+				return nil, err
+			}
+			return loadWebAuthnUser(db, userID)
+		}
+		user, credential, err := wa.ValidatePasskeyLogin(findUser, session.Data, parsed)
+		if err != nil {
+			logWebAuthnError("login", err)
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "WebAuthn verification failed", nil)
+			return
+		}
+		if !recordAssertion(db, credential) {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "WebAuthn verification failed", nil)
+			return
+		}
+
+		if signInRefused(w, db, user.(*webAuthnUser).user) {
+			return
+		}
+
+		writeLoginResponse(w, db, user.(*webAuthnUser).user)
+	}
+}
+
+// HandleMFAWebAuthnBegin starts a WebAuthn assertion as the second step of a
+// password login; the answer is sent to /login/mfa with the mfa_token
+func HandleMFAWebAuthnBegin(db *sql.DB, wa *webauthn.WebAuthn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MFAToken string `json:"mfa_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "Invalid request body", nil)
+			return
+		}
+
+		challenge, err := activeMFAChallenge(db, req.MFAToken)
+		if err == errInvalidMFAChallenge {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, false, "Invalid or expired MFA token", nil)
+			return
+		}
+		if err != nil {
+			log.Printf("GetMFAChallengeByHash error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+			return
+		}
+
+		user, err := loadWebAuthnUser(db, challenge.UserID)
+		if err != nil {
+			log.Printf("Load WebAuthn user error: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+			return
+		}
+		if len(user.credentials) == 0 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, false, "No security keys are registered", nil)
+			return
+		}
+
+		assertion, session, err := wa.BeginLogin(user)
+		if err != nil {
+			logWebAuthnError("login", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, false, "Failed to start WebAuthn ceremony", nil)
+			return
+		}
+		startWebAuthnSession(w, db, user.user.ID, model.WebAuthnPurposeMFA, session, assertion)
+	}
+}
+
+// verifyWebAuthnSecondFactor checks an assertion answering a session started
+// by HandleMFAWebAuthnBegin for the same user
+func verifyWebAuthnSecondFactor(db *sql.DB, wa *webauthn.WebAuthn, userID int, sessionToken string, response json.RawMessage) (bool, error) {
+	if wa == nil {
+		return false, nil
+	}
+
+	session, err := repository.ConsumeWebAuthnSession(db, utils.HashToken(sessionToken), model.WebAuthnPurposeMFA)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if session.UserID != userID {
+		return false, nil
+	}
+
+	user, err := loadWebAuthnUser(db, userID)
+	if err != nil {
+		return false, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		logWebAuthnError("login", err)
+		return false, nil
+	}
+	credential, err := wa.ValidateLogin(user, session.Data, parsed)
+	if err != nil {
+		logWebAuthnError("login", err)
+		return false, nil
+	}
+	return recordAssertion(db, credential), nil
+}
@@ -0,0 +1,324 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"golang_projects/mailer"
+	"golang_projects/repository"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gorilla/mux"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+// softAuthenticator is a software security key: an ES256 key pair with a
+// signature counter, answering ceremonies the way a browser relays them
+type softAuthenticator struct {
+	t          *testing.T
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	counter    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, userID int) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credID := make([]byte, 16)
+	if _, err := rand.Read(credID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{t: t, key: key, credID: credID, userHandle: []byte(strconv.Itoa(userID))}
+}
+
+// clientData returns the clientDataJSON of a ceremony
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": testOrigin})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+// authData returns the authenticator data header: RP id hash, flags and counter
+func (a *softAuthenticator) authData(flags byte, counter uint32) *bytes.Buffer {
+	var data bytes.Buffer
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data.Write(rpIDHash[:])
+	data.WriteByte(flags)
+	binary.Write(&data, binary.BigEndian, counter)
+	return &data
+}
+
+// create answers a registration ceremony with "none" attestation
+func (a *softAuthenticator) create(challenge string) map[string]interface{} {
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1: 2, 3: -7, -1: 1,
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	// user present, user verified, attested credential data included
+	data := a.authData(0x45, a.counter)
+	data.Write(make([]byte, 16))
+	binary.Write(data, binary.BigEndian, uint16(len(a.credID)))
+	data.Write(a.credID)
+	data.Write(publicKey)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": data.Bytes(),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    b64(a.clientData("webauthn.create", challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers an authentication ceremony, advancing the signature counter
+func (a *softAuthenticator) get(challenge string) map[string]interface{} {
+	a.counter++
+	return a.getWithCounter(challenge, a.counter)
+}
+
+// getWithCounter answers an authentication ceremony reporting the given counter
+func (a *softAuthenticator) getWithCounter(challenge string, counter uint32) map[string]interface{} {
+	clientData := a.clientData("webauthn.get", challenge)
+	// user present, user verified
+	data := a.authData(0x05, counter)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(data.Bytes(), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(data.Bytes()),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(response map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       b64(a.credID),
+		"rawId":    b64(a.credID),
+		"type":     "public-key",
+		"response": response,
+	}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// newWebAuthnRouter returns the application router with WebAuthn enabled
+func newWebAuthnRouter(t *testing.T) (*mux.Router, *sql.DB) {
+	t.Helper()
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:                  testRPID,
+		RPDisplayName:         "Go-Authentication",
+		RPOrigins:             []string{testOrigin},
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newTestDB(t)
+	router := SetupRoutes(db, Config{
+		WebAuthn:          wa,
+		Mailer:            mailer.OutboxMailer{Dir: t.TempDir(), From: "no-reply@localhost"},
+		DisableRateLimits: true,
+	})
+	return router, db
+}
+
+// webAuthnChallenge starts a ceremony and returns its session token and challenge
+func webAuthnChallenge(t *testing.T, h http.Handler, path, token string, body interface{}) (string, string) {
+	t.Helper()
+	code, resp := doJSON(t, h, http.MethodPost, path, token, body)
+	if code != http.StatusOK {
+		t.Fatalf("%s: %d %s", path, code, resp.Message)
+	}
+	var data struct {
+		SessionToken string `json:"session_token"`
+		Options      struct {
+			PublicKey struct {
+				Challenge string `json:"challenge"`
+			} `json:"publicKey"`
+		} `json:"options"`
+	}
+	decodeData(t, resp, &data)
+	if data.SessionToken == "" || data.Options.PublicKey.Challenge == "" {
+		t.Fatalf("%s returned no challenge: %s", path, resp.Data)
+	}
+	return data.SessionToken, data.Options.PublicKey.Challenge
+}
+
+// registerSoftAuthenticator registers a new software security key for the user
+func registerSoftAuthenticator(t *testing.T, h http.Handler, email string, userID int) *softAuthenticator {
+	t.Helper()
+	token := loginTokens(t, h, email)
+	key := newSoftAuthenticator(t, userID)
+
+	session, challenge := webAuthnChallenge(t, h, "/api/v1/mobile/webauthn/register/begin", token, map[string]string{})
+	body := map[string]interface{}{"session_token": session, "name": "soft key", "credential": key.create(challenge)}
+	if code, resp := doJSON(t, h, http.MethodPost, "/api/v1/mobile/webauthn/register/finish", token, body); code != http.StatusCreated {
+		t.Fatalf("register finish: %d %s", code, resp.Message)
+	}
+
+	// the registration session is single use
+	if code, _ := doJSON(t, h, http.MethodPost, "/api/v1/mobile/webauthn/register/finish", token, body); code == http.StatusCreated {
+		t.Fatal("replayed registration session was accepted")
+	}
+	return key
+}
+
+// passkeyLogin signs in with the authenticator's answer to a fresh challenge
+func passkeyLogin(t *testing.T, h http.Handler, answer func(challenge string) map[string]interface{}) (int, apiResponse) {
+	t.Helper()
+	session, challenge := webAuthnChallenge(t, h, "/api/v1/public/login/webauthn/begin", "", map[string]string{})
+	return doJSON(t, h, http.MethodPost, "/api/v1/public/login/webauthn/finish", "",
+		map[string]interface{}{"session_token": session, "credential": answer(challenge)})
+}
+
+func TestWebAuthnPasswordlessLogin(t *testing.T) {
+	router, db := newWebAuthnRouter(t)
+	userID := createTestUser(t, db, "Alice", "alice@example.com")
+	key := registerSoftAuthenticator(t, router, "alice@example.com", userID)
+
+	code, resp := passkeyLogin(t, router, key.get)
+	if code != http.StatusOK {
+		t.Fatalf("passkey login: %d %s", code, resp.Message)
+	}
+	var tokens TokenResponse
+	decodeData(t, resp, &tokens)
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("passkey login returned no tokens: %s", resp.Data)
+	}
+
+	// an answer to another challenge is rejected
+	code, _ = passkeyLogin(t, router, func(string) map[string]interface{} {
+		return key.get(b64([]byte("not the challenge")))
+	})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("answer to a wrong challenge: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestWebAuthnSecondFactor(t *testing.T) {
+	router, db := newWebAuthnRouter(t)
+	userID := createTestUser(t, db, "Bob", "bob@example.com")
+	key := registerSoftAuthenticator(t, router, "bob@example.com", userID)
+
+	code, resp := doJSON(t, router, http.MethodPost, "/api/v1/public/login", "",
+		map[string]string{"email": "bob@example.com", "password": testPassword})
+	if code != http.StatusOK {
+		t.Fatalf("login: %d %s", code, resp.Message)
+	}
+	var challenge MFAChallengeResponse
+	decodeData(t, resp, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("login with a security key did not ask for a second factor: %s", resp.Data)
+	}
+	if !slices.Contains(challenge.Methods, "webauthn") {
+		t.Fatalf("methods %v do not include webauthn", challenge.Methods)
+	}
+
+	session, assertionChallenge := webAuthnChallenge(t, router, "/api/v1/public/login/mfa/webauthn", "",
+		map[string]string{"mfa_token": challenge.MFAToken})
+	code, resp = doJSON(t, router, http.MethodPost, "/api/v1/public/login/mfa", "", map[string]interface{}{
+		"mfa_token":     challenge.MFAToken,
+		"session_token": session,
+		"credential":    key.get(assertionChallenge),
+	})
+	if code != http.StatusOK {
+		t.Fatalf("second factor: %d %s", code, resp.Message)
+	}
+	var tokens TokenResponse
+	decodeData(t, resp, &tokens)
+	if tokens.AccessToken == "" {
+		t.Fatalf("second factor returned no tokens: %s", resp.Data)
+	}
+
+	// the MFA token cannot be used again
+	code, _ = doJSON(t, router, http.MethodPost, "/api/v1/public/login/mfa/webauthn", "",
+		map[string]string{"mfa_token": challenge.MFAToken})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("used MFA token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestWebAuthnCloneWarning(t *testing.T) {
+	router, db := newWebAuthnRouter(t)
+	userID := createTestUser(t, db, "Carol", "carol@example.com")
+	key := registerSoftAuthenticator(t, router, "carol@example.com", userID)
+
+	key.counter = 10
+	if code, resp := passkeyLogin(t, router, key.get); code != http.StatusOK {
+		t.Fatalf("passkey login: %d %s", code, resp.Message)
+	}
+
+	// a counter that went backwards means the key may have been cloned
+	code, _ := passkeyLogin(t, router, func(challenge string) map[string]interface{} {
+		return key.getWithCounter(challenge, 5)
+	})
+	if code != http.StatusUnauthorized {
+		t.Fatalf("assertion with a lower counter: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestWebAuthnLoginRefusedForLockedOrUnverifiedAccount(t *testing.T) {
+	router, db := newWebAuthnRouter(t)
+	userID := createTestUser(t, db, "Dave", "dave@example.com")
+	key := registerSoftAuthenticator(t, router, "dave@example.com", userID)
+
+	if _, err := repository.RecordFailedLogin(db, userID, 1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if code, resp := passkeyLogin(t, router, key.get); code != http.StatusLocked {
+		t.Fatalf("passkey login to a locked account: got %d %s, want %d", code, resp.Message, http.StatusLocked)
+	}
+
+	if _, err := repository.UnlockUser(db, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET email_verified_at = NULL WHERE id = ?", userID); err != nil {
+		t.Fatal(err)
+	}
+	if code, resp := passkeyLogin(t, router, key.get); code != http.StatusForbidden {
+		t.Fatalf("passkey login to an unverified account: got %d %s, want %d", code, resp.Message, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,57 @@
+package routes
+
+import (
+	"encoding/json"
+	utils "golang_projects/utility"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// WellKnownRoutes registers the discovery documents served from the site root
+func WellKnownRoutes(r *mux.Router) {
+	r.HandleFunc("/.well-known/jwks.json", HandleJWKS()).Methods("GET")
+	r.HandleFunc("/.well-known/openid-configuration", HandleOpenIDConfiguration()).Methods("GET")
+}
+
+// HandleJWKS publishes the current and previous public signing keys
+func HandleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeRawJSON(w, http.StatusOK, utils.PublicJWKS())
+	}
+}
+
+// HandleOpenIDConfiguration serves the OpenID Connect discovery document
+func HandleOpenIDConfiguration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		document := map[string]interface{}{
+			"issuer":                                utils.Issuer,
+			"authorization_endpoint":                utils.Issuer + "/authorize",
+			"token_endpoint":                        utils.Issuer + "/token",
+			"userinfo_endpoint":                     utils.Issuer + "/userinfo",
+			"jwks_uri":                              utils.Issuer + "/.well-known/jwks.json",
+			"response_types_supported":              []string{"code"},
+			"grant_types_supported":                 supportedGrantTypes,
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{utils.IDTokenSigningAlg()},
+			"scopes_supported":                      supportedScopes,
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "email", "phone_number", "address"},
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeRawJSON(w, http.StatusOK, document)
+	}
+}
+
+// writeRawJSON writes a JSON document without the standard response envelope,
+// for endpoints whose format is fixed by a specification
+func writeRawJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Write JSON error: %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+// Package sms sends text messages such as one-time codes.
+package sms
+
+import "log"
+
+// Sender delivers a text message to a phone number
+type Sender interface {
+	Send(to, body string) error
+}
+
+// LogSender writes each message to the server log instead of sending it, for
+// local development and tests
+type LogSender struct{}
+
+// Send implements Sender
+func (LogSender) Send(to, body string) error {
+	log.Printf("SMS to %s: %s", to, body)
+	return nil
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnv returns the value of the environment variable or the fallback if unset
+func GetEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvInt returns the environment variable parsed as an int or the fallback
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %v, using default %d", key, err, fallback)
+		return fallback
+	}
+	return n
+}
+
+// GetEnvDuration returns the environment variable parsed as a duration (e.g. "15m") or the fallback
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %v, using default %s", key, err, fallback)
+		return fallback
+	}
+	return d
+}
+
+// GetEnvBool returns the environment variable parsed as a bool (e.g. "true", "0") or the fallback
+func GetEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s: %v, using default %t", key, err, fallback)
+		return fallback
+	}
+	return b
+}
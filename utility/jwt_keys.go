@@ -0,0 +1,438 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// devSecret is only used when JWT_SECRET is not configured. Never rely on it in production.
+var devSecret = []byte("a3d5f8u4n1m9p0z6w7x2q4t8v3b1y9r0")
+
+// SigningKey is a key used to sign or verify tokens, identified by the `kid` header
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private holds the signing material ([]byte for HMAC, crypto.Signer otherwise).
+	// It is nil for verification-only keys.
+	Private interface{}
+	// Public holds the verification material ([]byte for HMAC)
+	Public interface{}
+	// RetireAt is when a rotated-out key stops being accepted; zero means never
+	RetireAt time.Time
+}
+
+// CanSign reports whether the key holds signing material
+func (k *SigningKey) CanSign() bool {
+	return k.Private != nil
+}
+
+// Retired reports whether the key is no longer accepted for verification
+func (k *SigningKey) Retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && now.After(k.RetireAt)
+}
+
+// KeySet holds the current signing key and every key accepted for verification
+type KeySet struct {
+	mu        sync.RWMutex
+	signingID string
+	keys      map[string]*SigningKey
+	order     []string
+}
+
+// NewKeySet creates a key set whose signing key is the given key
+func NewKeySet(signing *SigningKey) *KeySet {
+	ks := &KeySet{keys: make(map[string]*SigningKey)}
+	ks.Add(signing)
+	ks.signingID = signing.ID
+	return ks
+}
+
+// Add registers a key for verification (and signing, if it is later promoted)
+func (ks *KeySet) Add(key *SigningKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if _, exists := ks.keys[key.ID]; !exists {
+		ks.order = append(ks.order, key.ID)
+	}
+	ks.keys[key.ID] = key
+}
+
+// Signing returns the key currently used to sign new tokens
+func (ks *KeySet) Signing() *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[ks.signingID]
+}
+
+// Lookup returns the key with the given id if it is still accepted
+func (ks *KeySet) Lookup(kid string) (*SigningKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.keys[kid]
+	if !ok || key.Retired(time.Now()) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Keys returns every key still accepted for verification, signing key first
+func (ks *KeySet) Keys() []*SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	now := time.Now()
+	keys := []*SigningKey{ks.keys[ks.signingID]}
+	for _, kid := range ks.order {
+		if kid != ks.signingID && !ks.keys[kid].Retired(now) {
+			keys = append(keys, ks.keys[kid])
+		}
+	}
+	return keys
+}
+
+// Promote makes the given key the signing key. The previous signing key stays
+// valid for verification until retireAt so tokens already issued keep working.
+func (ks *KeySet) Promote(key *SigningKey, retireAt time.Time) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if previous, ok := ks.keys[ks.signingID]; ok && previous.ID != key.ID {
+		previous.RetireAt = retireAt
+	}
+	if _, exists := ks.keys[key.ID]; !exists {
+		ks.order = append(ks.order, key.ID)
+	}
+	ks.keys[key.ID] = key
+	ks.signingID = key.ID
+}
+
+// jwtKeys is the key set used by GenerateJWT and ValidateJWT
+var jwtKeys = NewKeySet(&SigningKey{
+	ID:      "default",
+	Method:  jwt.SigningMethodHS256,
+	Private: devSecret,
+	Public:  devSecret,
+})
+
+// JWTKeys returns the active key set
+func JWTKeys() *KeySet {
+	return jwtKeys
+}
+
+// KeyRetention is how long a rotated-out key keeps verifying tokens. It must
+// cover the lifetime of the longest-lived token signed with it.
+var KeyRetention = GetEnvDuration("JWT_KEY_RETENTION", 24*time.Hour)
+
+// NewHMACKey generates a random HMAC key for the given method
+func NewHMACKey(method jwt.SigningMethod) (*SigningKey, error) {
+	if _, ok := method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("%s is not an HMAC algorithm", method.Alg())
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	kid, err := GenerateOpaqueToken(9)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: kid, Method: method, Private: secret, Public: secret}, nil
+}
+
+// RestoreHMACKey re-adds a key rotated in at runtime (persisted elsewhere) to
+// the key ring, promoting it to signing key when signing is true.
+func RestoreHMACKey(kid, alg string, secret []byte, retireAt time.Time, signing bool) error {
+	method := jwt.GetSigningMethod(alg)
+	if _, ok := method.(*jwt.SigningMethodHMAC); !ok {
+		return fmt.Errorf("%s is not an HMAC algorithm", alg)
+	}
+	if _, ok := jwtKeys.Signing().Method.(*jwt.SigningMethodHMAC); !ok {
+		return errors.New("signing keys are not HMAC")
+	}
+	key := &SigningKey{ID: kid, Method: method, Private: secret, Public: secret, RetireAt: retireAt}
+	if signing {
+		jwtKeys.Promote(key, time.Now().Add(KeyRetention))
+		return nil
+	}
+	jwtKeys.Add(key)
+	return nil
+}
+
+// InitJWTKeys loads the signing configuration from the environment:
+//
+//	JWT_ALG              HS256 (default), HS384, HS512, RS256, RS384, RS512, ES256, ES384, ES512 or EdDSA
+//	JWT_SECRET           HMAC secret for HS* algorithms
+//	JWT_HMAC_KEYS        optional HMAC key ring as comma separated kid:secret pairs (overrides JWT_SECRET)
+//	JWT_SIGNING_KID      kid of the HMAC key used for signing (defaults to the first in JWT_HMAC_KEYS)
+//	JWT_PRIVATE_KEY_FILE PEM private key for asymmetric algorithms
+//	JWT_KEY_ID           optional kid for the signing key (derived from the key when empty)
+//	JWT_VERIFY_KEYS      optional comma separated list of [kid=]path public key PEMs accepted for verification only
+func InitJWTKeys() error {
+	alg := GetEnv("JWT_ALG", "HS256")
+	method := jwt.GetSigningMethod(alg)
+	if method == nil || alg == "none" {
+		return fmt.Errorf("unsupported JWT_ALG %q", alg)
+	}
+
+	var ks *KeySet
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		var err error
+		ks, err = loadHMACKeyRing(method)
+		if err != nil {
+			return err
+		}
+	} else {
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", alg)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read JWT private key: %w", err)
+		}
+		private, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return err
+		}
+		keyMethod, err := methodForKey(private.Public())
+		if err != nil {
+			return err
+		}
+		if !compatibleMethods(method, keyMethod) {
+			return fmt.Errorf("JWT_PRIVATE_KEY_FILE does not hold a key for %s", alg)
+		}
+		signing := &SigningKey{Method: method, Private: private, Public: private.Public()}
+		signing.ID = GetEnv("JWT_KEY_ID", "")
+		if signing.ID == "" {
+			signing.ID = deriveKeyID(signing.Public)
+		}
+		ks = NewKeySet(signing)
+	}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			kid, path = entry[:i], entry[i+1:]
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read JWT verification key: %w", err)
+		}
+		public, err := ParsePublicKeyPEM(data)
+		if err != nil {
+			return err
+		}
+		keyMethod, err := methodForKey(public)
+		if err != nil {
+			return err
+		}
+		if kid == "" {
+			kid = deriveKeyID(public)
+		}
+		ks.Add(&SigningKey{ID: kid, Method: keyMethod, Public: public})
+	}
+
+	jwtKeys = ks
+	log.Printf("JWT signing with %s (kid %s)", ks.Signing().Method.Alg(), ks.Signing().ID)
+	return nil
+}
+
+// loadHMACKeyRing builds the HMAC key ring from JWT_HMAC_KEYS ("kid:secret,...")
+// with JWT_SIGNING_KID selecting the signing key, falling back to JWT_SECRET.
+func loadHMACKeyRing(method jwt.SigningMethod) (*KeySet, error) {
+	var keys []*SigningKey
+	for _, entry := range strings.Split(os.Getenv("JWT_HMAC_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, ":")
+		if i <= 0 {
+			return nil, errors.New("JWT_HMAC_KEYS entries must be kid:secret")
+		}
+		secret := []byte(entry[i+1:])
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("HMAC key %q must be at least 32 bytes", entry[:i])
+		}
+		keys = append(keys, &SigningKey{ID: entry[:i], Method: method, Private: secret, Public: secret})
+	}
+
+	if len(keys) == 0 {
+		secret := []byte(os.Getenv("JWT_SECRET"))
+		if len(secret) == 0 {
+			log.Println("WARNING: JWT_SECRET is not set, using the insecure development secret")
+			secret = devSecret
+		}
+		if len(secret) < 32 {
+			return nil, errors.New("JWT_SECRET must be at least 32 bytes")
+		}
+		kid := GetEnv("JWT_KEY_ID", "")
+		if kid == "" {
+			kid = hmacKeyID(secret)
+		}
+		return NewKeySet(&SigningKey{ID: kid, Method: method, Private: secret, Public: secret}), nil
+	}
+
+	signingID := GetEnv("JWT_SIGNING_KID", keys[0].ID)
+	var ks *KeySet
+	for _, key := range keys {
+		if key.ID == signingID {
+			ks = NewKeySet(key)
+		}
+	}
+	if ks == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KID %q is not in JWT_HMAC_KEYS", signingID)
+	}
+	for _, key := range keys {
+		ks.Add(key)
+	}
+	return ks, nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 or SEC 1 encoded private key
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported PEM private key format")
+}
+
+// ParsePublicKeyPEM parses a PKIX public key or the public key of a certificate
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM public key")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported PEM public key format")
+}
+
+// methodForKey returns the default signing method for an asymmetric public key
+func methodForKey(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, errors.New("unsupported elliptic curve")
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
+
+// compatibleMethods reports whether a configured method can be used with a key's default method
+func compatibleMethods(configured, fromKey jwt.SigningMethod) bool {
+	switch configured.(type) {
+	case *jwt.SigningMethodRSA:
+		_, ok := fromKey.(*jwt.SigningMethodRSA)
+		return ok
+	default:
+		return configured.Alg() == fromKey.Alg()
+	}
+}
+
+// isHMAC reports whether a signing method uses a shared secret
+func isHMAC(method jwt.SigningMethod) bool {
+	_, ok := method.(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// deriveKeyID derives a stable kid from an asymmetric public key. HMAC secrets
+// must never be passed here since the kid is published in every token header;
+// hmacKeyID derives theirs.
+func deriveKeyID(public crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "default"
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// hmacKeyID derives a kid for an HMAC secret, so that restarts and replicas
+// sharing JWT_SECRET agree on it. It is a MAC of a fixed label under the
+// secret, which reveals no more about the secret than a token signature does.
+func hmacKeyID(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("jwt key id"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:9])
+}
+
+// signClaims signs the claims with the current signing key and sets the kid header
+func signClaims(claims jwt.Claims) (string, error) {
+	key := jwtKeys.Signing()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// verificationKey selects the key for a token based on its kid header and algorithm
+func verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("missing kid header")
+	}
+	key, ok := jwtKeys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.Public, nil
+}
+
+// supportedAlgs lists the algorithms accepted by the token parser
+var supportedAlgs = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
@@ -2,42 +2,300 @@ package utils
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte("a3d5f8u4n1m9p0z6w7x2q4t8v3b1y9r0")
+// AccessTokenTTL is the lifetime of access tokens issued by GenerateJWT
+var AccessTokenTTL = GetEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
 
-// Change this to a strong secret key
+// RefreshTokenTTL is the lifetime of refresh tokens issued alongside access tokens
+var RefreshTokenTTL = GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
 
-// GenerateJWT generates a new JWT token
-func GenerateJWT(userID int) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+// Errors returned by ValidateJWT, one per reason a token can be rejected
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenInvalidSignature = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token has expired")
+	ErrTokenNotYetValid      = errors.New("token is not valid yet")
+	ErrTokenUsedBeforeIssued = errors.New("token used before issued")
+	ErrTokenInvalidIssuer    = errors.New("token has invalid issuer")
+	ErrTokenInvalidAudience  = errors.New("token has invalid audience")
+	ErrTokenMissingClaim     = errors.New("token is missing a required claim")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+)
+
+// revocationChecker reports whether a token id has been revoked
+var revocationChecker func(jti string) (bool, error)
+
+// SetRevocationChecker registers the lookup used by ValidateJWT to reject revoked tokens
+func SetRevocationChecker(checker func(jti string) (bool, error)) {
+	revocationChecker = checker
+}
+
+// sessionRevocationChecker reports whether a user's tokens issued at the given
+// time have been revoked by signing the user out everywhere
+var sessionRevocationChecker func(userID int, issuedAt time.Time) (bool, error)
+
+// SetSessionRevocationChecker registers the lookup used by ValidateJWT to reject
+// user tokens issued before the user's sessions were revoked
+func SetSessionRevocationChecker(checker func(userID int, issuedAt time.Time) (bool, error)) {
+	sessionRevocationChecker = checker
+}
+
+// Subject types distinguish tokens issued to users from tokens issued to service clients
+const (
+	SubjectTypeUser   = "user"
+	SubjectTypeClient = "client"
+)
+
+// Issuer is the public base URL of this service, used as the OpenID Connect issuer
+var Issuer = strings.TrimRight(GetEnv("ISSUER_URL", "http://localhost:8080"), "/")
+
+// TokenIssuer is the `iss` claim of access tokens
+var TokenIssuer = GetEnv("JWT_ISSUER", Issuer)
+
+// TokenAudience is the `aud` claim of access tokens; tokens minted for another
+// audience (another environment or an ID token) are rejected
+var TokenAudience = GetEnv("JWT_AUDIENCE", Issuer+"/api")
+
+// OAuthTokenAudience is the `aud` claim of access tokens issued to OAuth clients
+// on behalf of a user. It sets them apart from first-party tokens: they only
+// reach the routes and permissions their scopes grant.
+var OAuthTokenAudience = GetEnv("OAUTH_AUDIENCE", Issuer+"/oauth")
+
+// ClockSkewLeeway is the tolerance applied to exp, nbf and iat checks
+var ClockSkewLeeway = GetEnvDuration("JWT_LEEWAY", 30*time.Second)
+
+// IDTokenTTL is the lifetime of OpenID Connect ID tokens
+var IDTokenTTL = GetEnvDuration("ID_TOKEN_TTL", time.Hour)
+
+// GenerateJWT generates a new short-lived access token carrying the user's roles
+func GenerateJWT(userID int, roles []string) (string, error) {
+	if roles == nil {
+		roles = []string{}
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return generateAccessToken(userID, jwt.MapClaims{"roles": roles})
 }
 
-// ValidateJWT validates the given JWT token
-func ValidateJWT(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
+// GenerateServiceJWT generates an access token for a service client acting on its
+// own behalf (client credentials grant). The subject is the client, not a user.
+func GenerateServiceJWT(clientID, scope string) (string, error) {
+	jti, err := GenerateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := standardClaims(clientID, jti)
+	claims["sub_type"] = SubjectTypeClient
+	claims["client_id"] = clientID
+	claims["scope"] = scope
+	return signClaims(claims)
+}
+
+// GenerateOAuthJWT generates an access token issued to an OAuth client for the
+// given scope. Its audience is OAuthTokenAudience, not the first-party audience.
+func GenerateOAuthJWT(userID int, clientID, scope string) (string, error) {
+	return generateAccessToken(userID, jwt.MapClaims{
+		"aud":       OAuthTokenAudience,
+		"client_id": clientID,
+		"scope":     scope,
 	})
+}
+
+// generateAccessToken signs the standard access token claims plus any extra claims
+func generateAccessToken(userID int, extra jwt.MapClaims) (string, error) {
+	jti, err := GenerateOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	claims := standardClaims(strconv.Itoa(userID), jti)
+	claims["user_id"] = userID
+	claims["sub_type"] = SubjectTypeUser
+	for name, value := range extra {
+		claims[name] = value
+	}
+	return signClaims(claims)
+}
 
-	if err != nil || !token.Valid {
-		return nil, errors.New("invalid token")
+// standardClaims returns the registered claims shared by every access token
+func standardClaims(subject, jti string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": TokenIssuer,
+		"aud": TokenAudience,
+		"sub": subject,
+		"jti": jti,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(AccessTokenTTL).Unix(),
+	}
+}
+
+// ErrIDTokenKeyUnavailable is returned by GenerateIDToken when the signing keys
+// are HMAC and the client has no secret to sign the ID token with
+var ErrIDTokenKeyUnavailable = errors.New("no key the client can verify an ID token with")
+
+// IDTokenSigningAlg is the algorithm ID tokens are signed with: the asymmetric
+// signing key's, published in the JWKS, or HS256 keyed with the client secret
+// when the server signs with private HMAC secrets relying parties cannot use.
+func IDTokenSigningAlg() string {
+	if IDTokensUseClientSecret() {
+		return jwt.SigningMethodHS256.Alg()
+	}
+	return jwtKeys.Signing().Method.Alg()
+}
+
+// IDTokensUseClientSecret reports whether ID tokens are signed with the client
+// secret, which public clients do not have
+func IDTokensUseClientSecret() bool {
+	return isHMAC(jwtKeys.Signing().Method)
+}
+
+// GenerateIDToken generates an OpenID Connect ID token for the client (audience).
+// profile holds the user claims released for the granted scopes. With HMAC
+// signing keys the token is signed with clientSecret (OpenID Connect Core 10.1),
+// so public clients can only receive ID tokens from asymmetric keys.
+func GenerateIDToken(userID int, clientID, clientSecret, nonce string, authTime time.Time, profile map[string]interface{}) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":       Issuer,
+		"sub":       strconv.Itoa(userID),
+		"aud":       clientID,
+		"exp":       now.Add(IDTokenTTL).Unix(),
+		"iat":       now.Unix(),
+		"auth_time": authTime.Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for name, value := range profile {
+		claims[name] = value
+	}
+
+	if !IDTokensUseClientSecret() {
+		return signClaims(claims)
+	}
+	if clientSecret == "" {
+		return "", ErrIDTokenKeyUnavailable
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(clientSecret))
+}
+
+// ValidateJWT validates the given access token: signature, exp, nbf and iat
+// (with ClockSkewLeeway), issuer, audience (TokenAudience or
+// OAuthTokenAudience), subject and revocation. The returned error identifies
+// the reason a token was rejected.
+func ValidateJWT(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, verificationKey,
+		jwt.WithValidMethods(supportedAlgs),
+		jwt.WithIssuer(TokenIssuer),
+		jwt.WithLeeway(ClockSkewLeeway),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, classifyTokenError(err)
+	}
+	if !token.Valid {
+		return nil, ErrTokenMalformed
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return nil, errors.New("invalid token claims")
+		return nil, ErrTokenMalformed
+	}
+
+	if err := validateAudience(claims); err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if sub == "" || jti == "" {
+		return nil, ErrTokenMissingClaim
+	}
+	if _, ok := claims["iat"]; !ok {
+		return nil, ErrTokenMissingClaim
+	}
+
+	if revocationChecker != nil {
+		revoked, err := revocationChecker(jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	if subType, _ := claims["sub_type"].(string); sessionRevocationChecker != nil && subType != SubjectTypeClient {
+		userID, err := strconv.Atoi(sub)
+		if err != nil {
+			return nil, ErrTokenMalformed
+		}
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil {
+			return nil, ErrTokenMissingClaim
+		}
+		revoked, err := sessionRevocationChecker(userID, iat.Time)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
 	}
 
 	return claims, nil
 }
+
+// validateAudience accepts first-party access tokens and tokens issued to OAuth
+// clients. The latter must name their client and scope, which is what
+// restricts them to the routes and permissions they were granted.
+func validateAudience(claims jwt.MapClaims) error {
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return ErrTokenMalformed
+	}
+
+	for _, audience := range aud {
+		switch audience {
+		case TokenAudience:
+			return nil
+		case OAuthTokenAudience:
+			clientID, _ := claims["client_id"].(string)
+			if _, ok := claims["scope"].(string); !ok || clientID == "" {
+				return ErrTokenMissingClaim
+			}
+			return nil
+		}
+	}
+	return ErrTokenInvalidAudience
+}
+
+// classifyTokenError maps parser errors to the ValidateJWT error reasons
+func classifyTokenError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return ErrTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrTokenUsedBeforeIssued
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return ErrTokenInvalidIssuer
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return ErrTokenInvalidAudience
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return ErrTokenMissingClaim
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrTokenInvalidSignature
+	default:
+		return ErrTokenMalformed
+	}
+}
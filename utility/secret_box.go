@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sealedPrefix marks a value produced by EncryptSecret. Legacy plaintext
+// values never start with it (base32 and raw key material have no '.').
+const sealedPrefix = "v1."
+
+// devEncryptionKey is only used when ENCRYPTION_KEY is not configured. Never rely on it in production.
+var devEncryptionKey = sha256.Sum256([]byte("go-authentication development key-encryption key"))
+
+// secretAEAD encrypts secrets stored in the database (rotated HMAC keys, TOTP seeds)
+var secretAEAD = mustAEAD(devEncryptionKey[:])
+
+// InitEncryptionKey loads the key-encryption key from ENCRYPTION_KEY, a
+// base64 encoded 32 byte key. Without it a development key is used.
+func InitEncryptionKey() error {
+	value := os.Getenv("ENCRYPTION_KEY")
+	if value == "" {
+		log.Println("WARNING: ENCRYPTION_KEY is not set, using the insecure development key-encryption key")
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(value)
+	}
+	if err != nil || len(key) != 32 {
+		return errors.New("ENCRYPTION_KEY must be a base64 encoded 32 byte key")
+	}
+	secretAEAD = mustAEAD(key)
+	return nil
+}
+
+func mustAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// EncryptSecret encrypts a secret for storage with AES-GCM. The purpose (e.g.
+// "totp:42") is authenticated so a sealed value cannot be moved to another row.
+func EncryptSecret(plaintext []byte, purpose string) (string, error) {
+	nonce := make([]byte, secretAEAD.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := secretAEAD.Seal(nonce, nonce, plaintext, []byte(purpose))
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret for the same purpose
+func DecryptSecret(value, purpose string) ([]byte, error) {
+	if !IsEncryptedSecret(value) {
+		return nil, errors.New("secret is not encrypted")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil || len(sealed) < secretAEAD.NonceSize() {
+		return nil, errors.New("malformed encrypted secret")
+	}
+	nonce, ciphertext := sealed[:secretAEAD.NonceSize()], sealed[secretAEAD.NonceSize():]
+	plaintext, err := secretAEAD.Open(nil, nonce, ciphertext, []byte(purpose))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secret: %w", err)
+	}
+	return plaintext, nil
+}
+
+// IsEncryptedSecret reports whether a stored value was produced by EncryptSecret
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// SigningKeyPurpose is the EncryptSecret purpose for a rotated HMAC signing key
+func SigningKeyPurpose(kid string) string {
+	return "jwt_signing_key:" + kid
+}
+
+// TOTPSecretPurpose is the EncryptSecret purpose for a user's TOTP secret
+func TOTPSecretPurpose(userID int) string {
+	return "totp:" + strconv.Itoa(userID)
+}
@@ -29,8 +29,6 @@ func ParseValidationErrors(err error) ValidationErrors {
 				validationErrors[fieldName] = fmt.Sprintf("%s must be at most %s characters long", fieldName, e.Param())
 			case "gt":
 				validationErrors[fieldName] = fmt.Sprintf("%s must be greater than %s", fieldName, e.Param())
-			case "strongpwd":
-				validationErrors[fieldName] = fmt.Sprintf("%s must contain at least one uppercase letter, one lowercase letter, and one number", fieldName)
 			default:
 				validationErrors[fieldName] = fmt.Sprintf("%s is not valid", fieldName)
 			}